@@ -4,9 +4,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	log "github.com/Sirupsen/logrus"
-	"os"
-	"path/filepath"
 	"strings"
 )
 
@@ -27,6 +24,12 @@ type DataRequest struct {
 	Data string `json:"data"`
 }
 
+// IsNotFound reports whether err is the HTTP 404 the server returns for keys
+// which do not exist
+func IsNotFound(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "HTTP Error 404")
+}
+
 func (c *Client) AdminGetKeyAsTEXT(keyName string) (string, error) {
 	resp, err := c.GETRequestTEXT(fmt.Sprintf("/admin/key/%s", keyName))
 	if err != nil {
@@ -36,15 +39,25 @@ func (c *Client) AdminGetKeyAsTEXT(keyName string) (string, error) {
 	return string(resp), err
 }
 
-func (c *Client) AdminGetKeyAsJSON(keyName string) ([]byte, error) {
-	var jsonblob = make([]byte, 0)
+func (c *Client) AdminGetKey(keyName string) (KeyResponse, error) {
+	var keyResponse KeyResponse
 
 	resp, err := c.GETRequestJSON(fmt.Sprintf("/admin/key/%s", keyName))
 	if err != nil {
-		return jsonblob, err
+		return keyResponse, err
 	}
-	var keyResponse KeyResponse
-	if err := json.Unmarshal(resp, &keyResponse); err != nil {
+	if err = json.Unmarshal(resp, &keyResponse); err != nil {
+		return keyResponse, err
+	}
+	registerKeySecrets(keyName, keyResponse)
+	return keyResponse, err
+}
+
+func (c *Client) AdminGetKeyAsJSON(keyName string) ([]byte, error) {
+	var jsonblob = make([]byte, 0)
+
+	keyResponse, err := c.AdminGetKey(keyName)
+	if err != nil {
 		return jsonblob, err
 	}
 	jsonblob, err = json.MarshalIndent(keyResponse, "", "  ")
@@ -56,8 +69,11 @@ func (c *Client) AdminGetKeyAsJSON(keyName string) ([]byte, error) {
 }
 
 func (c *Client) AdminSetKeyFromJSON(keyName string, jsonblob []byte) error {
+	if err := c.checkUnchanged(keyName); err != nil {
+		return err
+	}
 	_, err := c.POSTRequestJSON(fmt.Sprintf("/admin/key/%s", keyName), jsonblob)
-	return err
+	return conflictError(keyName, err)
 }
 
 func (c *Client) AdminGetHashField(keyName string, fieldName string) (string, error) {
@@ -70,7 +86,11 @@ func (c *Client) AdminGetHashField(keyName string, fieldName string) (string, er
 	if err := json.Unmarshal(resp, &keyResponse); err != nil {
 		return "", err
 	}
-	return keyResponse.Data.(string), err
+	val := keyResponse.Data.(string)
+	if IsSecretValue(keyName, fieldName, val) {
+		RegisterSecret(val)
+	}
+	return val, err
 }
 
 func (c *Client) AdminGetListIndex(keyName string, index string) (string, error) {
@@ -83,29 +103,28 @@ func (c *Client) AdminGetListIndex(keyName string, index string) (string, error)
 	if err := json.Unmarshal(resp, &keyResponse); err != nil {
 		return "", err
 	}
-	return keyResponse.Data.(string), err
+	val := keyResponse.Data.(string)
+	registerLookedUpSecret(keyName, val)
+	return val, err
 }
 
 func (c *Client) AdminSetStringKey(keyName string, value string) error {
-	ktype, err := c.AdminGetKeyType(keyName)
-	if err != nil {
-		// Ignore 404
-		if !strings.Contains(err.Error(), "404") {
-			// This is bad, string matching an error, but it gets shit done for now
-			return err
-		}
-		// Key does not exist - so we will create a string key
-		ktype = "string"
+	// The type check and the write are one compare-and-set, so the key
+	// cannot turn into a list or hash in between
+	kr, version, err := c.adminGetForUpdate(keyName)
+	if err != nil && !IsNotFound(err) {
+		return err
 	}
-	if ktype != "string" {
-		return errors.New(fmt.Sprintf("Can only set keys of type 'string' via parameter - type '%s' not supported!", ktype))
+	// A key which does not exist is created as a string key
+	if err == nil && kr.Type != "string" {
+		return errors.New(fmt.Sprintf("Can only set keys of type 'string' via parameter - type '%s' not supported!", kr.Type))
 	}
 	req := &StringKeyResponse{
 		Type: "string",
 		Data: value,
 	}
 	jsonblob, err := json.Marshal(req)
-	err = c.AdminSetKeyFromJSON(keyName, jsonblob)
+	err = c.IfUnchanged(version).AdminSetKeyFromJSON(keyName, jsonblob)
 
 	return err
 }
@@ -118,8 +137,11 @@ func (c *Client) AdminListAppend(keyName string, value string) error {
 		return err
 	}
 
+	if err := c.checkUnchanged(keyName); err != nil {
+		return err
+	}
 	_, err = c.PATCHRequestJSON(fmt.Sprintf("/admin/key/append/%s", keyName), jsonblob)
-	return err
+	return conflictError(keyName, err)
 }
 
 func (c *Client) AdminSetHashField(keyName string, fieldName string, value string) error {
@@ -130,13 +152,19 @@ func (c *Client) AdminSetHashField(keyName string, fieldName string, value strin
 		return err
 	}
 
+	if err := c.checkUnchanged(keyName); err != nil {
+		return err
+	}
 	_, err = c.POSTRequestJSON(fmt.Sprintf("/admin/key/%s/%s", keyName, fieldName), jsonblob)
-	return err
+	return conflictError(keyName, err)
 }
 
 func (c *Client) AdminDeleteKey(keyName string) error {
+	if err := c.checkUnchanged(keyName); err != nil {
+		return err
+	}
 	_, err := c.DELETERequest(fmt.Sprintf("/admin/key/%s", keyName))
-	return err
+	return conflictError(keyName, err)
 }
 
 func (c *Client) AdminListKeys(filter string) ([]string, error) {
@@ -162,55 +190,6 @@ func (c *Client) AdminListKeys(filter string) ([]string, error) {
 	return resp.Data, err
 }
 
-func (c *Client) AdminDumpKeys(output string) error {
-	err := os.MkdirAll(output, 0755)
-	if err != nil {
-		return err
-	}
-	body, err := c.GETRequestJSON("/admin/keys/*")
-	if err != nil {
-		return err
-	}
-
-	var resp SimpleListResponse
-	if err = json.Unmarshal(body, &resp); err != nil {
-		return err
-	}
-
-	for _, k := range resp.Data {
-		log.Debugf("Dumping key %s", k)
-		resp, err := c.GETRequestJSON(fmt.Sprintf("/admin/key/%s", k))
-		if err != nil {
-			return err
-		}
-		var keyResponse KeyResponse
-		if err := json.Unmarshal(resp, &keyResponse); err != nil {
-			return err
-		}
-		jsonblob, err := json.MarshalIndent(keyResponse, "", "  ")
-		if err != nil {
-			return err
-		}
-
-		localfile := filepath.Join(output, k)
-		fh, err := os.OpenFile(localfile, os.O_WRONLY|os.O_CREATE, 0755)
-		if err != nil {
-			return err
-		}
-		log.Debugf("  Writing %d bytes to %s", len(jsonblob), localfile)
-		written, err := fh.Write(jsonblob)
-		if err != nil {
-			return err
-		}
-		log.Debugf("    Wrote %d bytes", written)
-		if written != len(jsonblob) {
-			return fmt.Errorf("Partial write for %s. Should have written %d, but only wrote %d", localfile, len(jsonblob), written)
-		}
-	}
-
-	return nil
-}
-
 func (c *Client) AdminListHashFields(keyName string) ([]string, error) {
 	fields := make([]string, 0)
 
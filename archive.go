@@ -0,0 +1,227 @@
+package confclient
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// ArchiveFormat identifies a dump archive in its header
+const ArchiveFormat = "confclient-dump"
+
+// ArchiveVersion is the archive format version written by WriteArchive
+const ArchiveVersion = 1
+
+// ArchiveHeader is the first line of a dump archive. Checksum is the sha256
+// of every following line, including newlines.
+type ArchiveHeader struct {
+	Format   string    `json:"format"`
+	Version  int       `json:"version"`
+	Server   string    `json:"server"`
+	Created  time.Time `json:"created"`
+	Count    int       `json:"count"`
+	Checksum string    `json:"sha256"`
+}
+
+// ArchiveRecord is one key in a dump archive. Checksum is the sha256 of the
+// key's type and data as JSON.
+type ArchiveRecord struct {
+	Key      string      `json:"key"`
+	Type     string      `json:"type"`
+	Data     interface{} `json:"data"`
+	Checksum string      `json:"sha256"`
+}
+
+// keyChecksum is the sha256 of a key's type and data as JSON
+func keyChecksum(kr KeyResponse) (string, error) {
+	b, err := json.Marshal(kr)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// WriteArchive writes keys as a JSON lines archive: a header line followed by
+// one line per key, ordered by key name. The records are encoded twice, first
+// for the header checksum, so the archive is streamed to w rather than built
+// in memory.
+func WriteArchive(w io.Writer, server string, keys map[string]KeyResponse) error {
+	names := sortedKeyNames(keys)
+	hash := sha256.New()
+	if err := writeArchiveRecords(hash, names, keys); err != nil {
+		return err
+	}
+
+	header := ArchiveHeader{
+		Format:   ArchiveFormat,
+		Version:  ArchiveVersion,
+		Server:   server,
+		Created:  time.Now().UTC(),
+		Count:    len(keys),
+		Checksum: hex.EncodeToString(hash.Sum(nil)),
+	}
+	if err := json.NewEncoder(w).Encode(header); err != nil {
+		return err
+	}
+	return writeArchiveRecords(w, names, keys)
+}
+
+// writeArchiveRecords writes one ArchiveRecord line for each of names
+func writeArchiveRecords(w io.Writer, names []string, keys map[string]KeyResponse) error {
+	enc := json.NewEncoder(w)
+	for _, k := range names {
+		kr := keys[k]
+		sum, err := keyChecksum(kr)
+		if err != nil {
+			return fmt.Errorf("Cannot encode key %s: %s", k, err)
+		}
+		if err := enc.Encode(ArchiveRecord{Key: k, Type: kr.Type, Data: kr.Data, Checksum: sum}); err != nil {
+			return fmt.Errorf("Cannot encode key %s: %s", k, err)
+		}
+	}
+	return nil
+}
+
+// ReadArchive reads an archive written by WriteArchive, gzip compressed or
+// not, and verifies its key count and checksums
+func ReadArchive(r io.Reader) (*ArchiveHeader, map[string]KeyResponse, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Cannot read archive: %s", err)
+		}
+		defer gz.Close()
+		br = bufio.NewReader(gz)
+	}
+
+	line, err := br.ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return nil, nil, fmt.Errorf("Cannot read archive: %s", err)
+	}
+	var header ArchiveHeader
+	if err := json.Unmarshal(line, &header); err != nil || header.Format != ArchiveFormat {
+		return nil, nil, fmt.Errorf("Not a %s archive", ArchiveFormat)
+	}
+	if header.Version != ArchiveVersion {
+		return nil, nil, fmt.Errorf("Unsupported archive version %d", header.Version)
+	}
+
+	keys := make(map[string]KeyResponse)
+	hash := sha256.New()
+	for n := 2; ; n++ {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			hash.Write(line)
+			var rec ArchiveRecord
+			if err := json.Unmarshal(line, &rec); err != nil {
+				return nil, nil, fmt.Errorf("Cannot parse archive line %d: %s", n, err)
+			}
+			kr := KeyResponse{Type: rec.Type, Data: rec.Data}
+			if sum, _ := keyChecksum(kr); sum != rec.Checksum {
+				return nil, nil, fmt.Errorf("Checksum mismatch for key %s on archive line %d", rec.Key, n)
+			}
+			keys[rec.Key] = kr
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("Cannot read archive: %s", err)
+		}
+	}
+
+	if len(keys) != header.Count {
+		return nil, nil, fmt.Errorf("Archive should hold %d keys, found %d", header.Count, len(keys))
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != header.Checksum {
+		return nil, nil, fmt.Errorf("Archive checksum mismatch")
+	}
+	return &header, keys, nil
+}
+
+// IsArchivePath reports whether a dump path is written as an archive rather
+// than a directory: "-" (stdin/stdout) and *.jsonl or *.jsonl.gz files
+func IsArchivePath(path string) bool {
+	return path == "-" || strings.HasSuffix(path, ".jsonl") || strings.HasSuffix(path, ".jsonl.gz")
+}
+
+// ReadDump reads the keys of a dump directory or archive. path "-" reads an
+// archive from stdin.
+func ReadDump(path string) (map[string]KeyResponse, error) {
+	if path == "-" {
+		_, keys, err := ReadArchive(os.Stdin)
+		return keys, err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return ReadDumpDir(path)
+	}
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	_, keys, err := ReadArchive(fh)
+	return keys, err
+}
+
+// AdminDumpArchive writes the keys matching opts.Filter as an archive to
+// output, or to stdout if output is "-". Files ending in .gz are gzip
+// compressed and are replaced atomically. The keys are held in memory while
+// they are fetched, as the archive is sorted by key name, but the archive
+// itself is streamed to output.
+func (c *Client) AdminDumpArchive(output string, opts DumpOptions) error {
+	keys, err := c.adminGetKeys(opts)
+	if err != nil {
+		return err
+	}
+
+	if output == "-" {
+		return WriteArchive(os.Stdout, c.url, keys)
+	}
+	log.Debugf("Writing %d keys to %s", len(keys), output)
+	return writeStreamAtomic(output, 0644, func(w io.Writer) error {
+		if !strings.HasSuffix(output, ".gz") {
+			return WriteArchive(w, c.url, keys)
+		}
+		gz := gzip.NewWriter(w)
+		if err := WriteArchive(gz, c.url, keys); err != nil {
+			return err
+		}
+		return gz.Close()
+	})
+}
+
+var keyFileEscaper = strings.NewReplacer("%", "%25", "/", "%2F")
+var keyFileUnescaper = strings.NewReplacer("%25", "%", "%2F", "/")
+
+// KeyFileName is the file name a key is dumped to. '/' and '%' are escaped,
+// as is a leading '.' so keys cannot become hidden files.
+func KeyFileName(key string) string {
+	name := keyFileEscaper.Replace(key)
+	if strings.HasPrefix(name, ".") {
+		name = "%2E" + name[1:]
+	}
+	return name
+}
+
+// KeyFromFileName reverses KeyFileName
+func KeyFromFileName(name string) string {
+	if strings.HasPrefix(name, "%2E") {
+		name = "." + name[3:]
+	}
+	return keyFileUnescaper.Replace(name)
+}
@@ -0,0 +1,97 @@
+package confclient
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestArchiveRoundTrip(t *testing.T) {
+	keys := map[string]KeyResponse{
+		"app:name":     {"string", "app"},
+		"app/with/sep": {"list", []interface{}{"a", "b"}},
+		"app:db":       {"hash", map[string]interface{}{"host": "db1"}},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteArchive(&buf, "http://confmgr:8080", keys); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != 4 {
+		t.Errorf("Expected a header and 3 key lines, got %d lines", lines)
+	}
+
+	var gzbuf bytes.Buffer
+	gz := gzip.NewWriter(&gzbuf)
+	gz.Write(buf.Bytes())
+	gz.Close()
+
+	for _, r := range []*bytes.Buffer{bytes.NewBuffer(buf.Bytes()), &gzbuf} {
+		header, read, err := ReadArchive(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Server != "http://confmgr:8080" || header.Count != 3 {
+			t.Errorf("Unexpected header: %+v", header)
+		}
+		if !reflect.DeepEqual(read, keys) {
+			t.Errorf("Expected %v, got %v", keys, read)
+		}
+	}
+
+	tampered := strings.Replace(buf.String(), `"db1"`, `"db2"`, 1)
+	if _, _, err := ReadArchive(strings.NewReader(tampered)); err == nil || !strings.Contains(err.Error(), "app:db") {
+		t.Errorf("Expected checksum error for app:db, got %v", err)
+	}
+	truncated := buf.String()[:strings.LastIndex(strings.TrimSuffix(buf.String(), "\n"), "\n")+1]
+	if _, _, err := ReadArchive(strings.NewReader(truncated)); err == nil {
+		t.Errorf("Expected error reading a truncated archive")
+	}
+}
+
+func TestKeyFileName(t *testing.T) {
+	for _, key := range []string{"app:name", "a/b", "100%/x", ".hidden", "%2Ex"} {
+		name := KeyFileName(key)
+		if strings.Contains(name, "/") || strings.HasPrefix(name, ".") {
+			t.Errorf("Unsafe file name %s for key %s", name, key)
+		}
+		if back := KeyFromFileName(name); back != key {
+			t.Errorf("Expected %s, got %s", key, back)
+		}
+	}
+}
+
+func TestDumpArchiveAndLoad(t *testing.T) {
+	keys := map[string]KeyResponse{
+		"app/path": {"string", "/srv/app"},
+		"app:port": {"string", "80"},
+	}
+	ts := newTreeServer(t, keys)
+	defer ts.Close()
+	c := InitiateClient(ts.URL)
+
+	dir, _ := ioutil.TempDir("", "confadm")
+	defer os.RemoveAll(dir)
+	archive := filepath.Join(dir, "dump.jsonl.gz")
+	if err := c.AdminDumpArchive(archive, DumpOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AdminDumpKeys(filepath.Join(dir, "dir"), DumpOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{archive, filepath.Join(dir, "dir")} {
+		read, err := ReadDump(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(read, keys) {
+			t.Errorf("%s: expected %v, got %v", path, keys, read)
+		}
+	}
+}
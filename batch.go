@@ -0,0 +1,335 @@
+package confclient
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// Batch operations
+const (
+	BatchSet         = "set"
+	BatchDelete      = "del"
+	BatchSetField    = "hset"
+	BatchDeleteField = "hdel"
+	BatchAppend      = "rpush"
+	BatchPrepend     = "lpush"
+)
+
+// BatchOp is one operation of a Batch, and one line of a confadm batch file.
+// set takes a Type (default string) and Data like a key; hset, rpush and
+// lpush take a string Data. IfUnchanged is an optional key version, see
+// AdminGetKeyVersion.
+type BatchOp struct {
+	Op          string      `json:"op"`
+	Key         string      `json:"key"`
+	Field       string      `json:"field,omitempty"`
+	Type        string      `json:"type,omitempty"`
+	Data        interface{} `json:"data,omitempty"`
+	IfUnchanged string      `json:"if_unchanged,omitempty"`
+}
+
+// Batch is a list of operations on several keys which are applied together:
+// all operations are checked against the current keys before anything is
+// written, and if a write fails the keys already written are restored.
+type Batch struct {
+	c   *Client
+	Ops []BatchOp
+}
+
+// BatchError is returned when a batch failed part way. RolledBack are the
+// keys which were written and restored, NotRolledBack those which could not
+// be restored, e.g. because they were changed again in the meantime.
+type BatchError struct {
+	Key           string
+	Err           error
+	RolledBack    []string
+	NotRolledBack []string
+}
+
+func (e *BatchError) Error() string {
+	msg := fmt.Sprintf("Cannot write key %s: %s; %d keys rolled back", e.Key, e.Err, len(e.RolledBack))
+	if len(e.NotRolledBack) > 0 {
+		msg += fmt.Sprintf(", could not restore: %s", strings.Join(e.NotRolledBack, ", "))
+	}
+	return msg
+}
+
+// Batch starts an empty batch of admin writes
+func (c *Client) Batch() *Batch {
+	return &Batch{c: c, Ops: make([]BatchOp, 0)}
+}
+
+// Add adds op to the batch
+func (b *Batch) Add(op BatchOp) *Batch {
+	b.Ops = append(b.Ops, op)
+	return b
+}
+
+// Set replaces or creates keyName
+func (b *Batch) Set(keyName string, kr KeyResponse) *Batch {
+	return b.Add(BatchOp{Op: BatchSet, Key: keyName, Type: kr.Type, Data: kr.Data})
+}
+
+// Delete deletes keyName
+func (b *Batch) Delete(keyName string) *Batch {
+	return b.Add(BatchOp{Op: BatchDelete, Key: keyName})
+}
+
+// SetField sets one field of the hash keyName, creating it if needed
+func (b *Batch) SetField(keyName string, fieldName string, value string) *Batch {
+	return b.Add(BatchOp{Op: BatchSetField, Key: keyName, Field: fieldName, Data: value})
+}
+
+// DeleteField deletes one field of the hash keyName
+func (b *Batch) DeleteField(keyName string, fieldName string) *Batch {
+	return b.Add(BatchOp{Op: BatchDeleteField, Key: keyName, Field: fieldName})
+}
+
+// Append adds value to the end of the list keyName, creating it if needed
+func (b *Batch) Append(keyName string, value string) *Batch {
+	return b.Add(BatchOp{Op: BatchAppend, Key: keyName, Data: value})
+}
+
+// Prepend adds value to the start of the list keyName, creating it if needed
+func (b *Batch) Prepend(keyName string, value string) *Batch {
+	return b.Add(BatchOp{Op: BatchPrepend, Key: keyName, Data: value})
+}
+
+// ReadBatchOps reads one JSON BatchOp per line. Blank lines are skipped.
+func ReadBatchOps(r io.Reader) ([]BatchOp, error) {
+	ops := make([]BatchOp, 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var op BatchOp
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.UseNumber()
+		if err := dec.Decode(&op); err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+		ops = append(ops, op)
+	}
+	return ops, scanner.Err()
+}
+
+// batchPlan is the state of each key a batch touches, in the order they are
+// first used, before and after the batch. nil means the key does not exist.
+type batchPlan struct {
+	keys     []string
+	before   map[string]*KeyResponse
+	after    map[string]*KeyResponse
+	versions map[string]string
+}
+
+// Validate reads every key the batch touches and checks each operation
+// applies to it, e.g. hset to a hash. Every problem found is reported at
+// once. It returns the changes the batch would make.
+func (b *Batch) Validate() ([]KeyDiff, error) {
+	plan, err := b.plan()
+	if err != nil {
+		return nil, err
+	}
+	return plan.diffs(), nil
+}
+
+// Commit validates the batch, then writes each changed key. Keys are only
+// written if they did not change since they were read. If a write fails,
+// the keys already written are restored to their previous values and a
+// *BatchError is returned.
+func (b *Batch) Commit() ([]KeyDiff, error) {
+	plan, err := b.plan()
+	if err != nil {
+		return nil, err
+	}
+
+	written := make([]string, 0)
+	for _, k := range plan.keys {
+		if reflect.DeepEqual(plan.before[k], plan.after[k]) {
+			continue
+		}
+		if err := b.c.IfUnchanged(plan.versions[k]).adminWriteKey(k, plan.after[k]); err != nil {
+			return nil, b.rollback(plan, written, k, err)
+		}
+		log.WithFields(log.Fields{"key": k}).Debug("Wrote batch key")
+		written = append(written, k)
+	}
+	return plan.diffs(), nil
+}
+
+// rollback restores the written keys of plan after writing failed at key.
+// Keys are only restored while they still hold what the batch wrote.
+func (b *Batch) rollback(plan *batchPlan, written []string, key string, err error) error {
+	batchErr := &BatchError{Key: key, Err: err, RolledBack: make([]string, 0), NotRolledBack: make([]string, 0)}
+	for i := len(written) - 1; i >= 0; i-- {
+		k := written[i]
+		version := VersionAbsent
+		if plan.after[k] != nil {
+			version = KeyVersion(*plan.after[k])
+		}
+		if err := b.c.IfUnchanged(version).adminWriteKey(k, plan.before[k]); err != nil {
+			log.WithFields(log.Fields{"key": k}).Errorf("Cannot roll back key: %s", err)
+			batchErr.NotRolledBack = append(batchErr.NotRolledBack, k)
+			continue
+		}
+		batchErr.RolledBack = append(batchErr.RolledBack, k)
+	}
+	return batchErr
+}
+
+// adminWriteKey sets keyName to kr, or deletes it if kr is nil
+func (c *Client) adminWriteKey(keyName string, kr *KeyResponse) error {
+	if kr == nil {
+		return c.AdminDeleteKey(keyName)
+	}
+	return c.adminSetKey(keyName, *kr)
+}
+
+// plan reads the keys of the batch and applies its operations to them in
+// memory
+func (b *Batch) plan() (*batchPlan, error) {
+	plan := &batchPlan{
+		keys:     make([]string, 0),
+		before:   make(map[string]*KeyResponse),
+		after:    make(map[string]*KeyResponse),
+		versions: make(map[string]string),
+	}
+	if len(b.Ops) == 0 {
+		return nil, fmt.Errorf("Batch has no operations")
+	}
+
+	problems := make([]string, 0)
+	for i, op := range b.Ops {
+		if op.Key == "" {
+			problems = append(problems, fmt.Sprintf("operation %d: no key", i+1))
+			continue
+		}
+		if _, ok := plan.versions[op.Key]; !ok {
+			kr, version, err := b.c.AdminGetKeyVersion(op.Key)
+			if err != nil && !IsNotFound(err) {
+				return nil, fmt.Errorf("Cannot get key %s: %s", op.Key, err)
+			}
+			plan.keys = append(plan.keys, op.Key)
+			plan.versions[op.Key] = version
+			if err == nil {
+				before, after := kr, kr
+				plan.before[op.Key], plan.after[op.Key] = &before, &after
+			}
+		}
+		if op.IfUnchanged != "" {
+			before := KeyResponse{}
+			if plan.before[op.Key] != nil {
+				before = *plan.before[op.Key]
+			}
+			if !b.c.IfUnchanged(op.IfUnchanged).hasVersion(before, plan.versions[op.Key]) {
+				problems = append(problems, fmt.Sprintf("operation %d: key %s changed since version %s", i+1, op.Key, op.IfUnchanged))
+				continue
+			}
+		}
+
+		after, err := applyBatchOp(op, plan.after[op.Key])
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("operation %d (%s %s): %s", i+1, op.Op, op.Key, err))
+			continue
+		}
+		plan.after[op.Key] = after
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("Invalid batch:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return plan, nil
+}
+
+// applyBatchOp returns the key cur becomes after op. cur is not changed.
+func applyBatchOp(op BatchOp, cur *KeyResponse) (*KeyResponse, error) {
+	value, isString := op.Data.(string)
+	if n, ok := op.Data.(json.Number); ok {
+		value, isString = n.String(), true
+	}
+	switch op.Op {
+	case BatchSetField, BatchAppend, BatchPrepend:
+		if !isString {
+			return nil, fmt.Errorf("data must be a string")
+		}
+	}
+
+	switch op.Op {
+	case BatchSet:
+		kr := KeyResponse{Type: op.Type, Data: op.Data}
+		if kr.Type == "" {
+			kr.Type = "string"
+		}
+		kr, err := checkKey(kr)
+		if err != nil {
+			return nil, err
+		}
+		return &kr, nil
+	case BatchDelete:
+		if cur == nil {
+			return nil, fmt.Errorf("key does not exist")
+		}
+		return nil, nil
+	case BatchSetField, BatchDeleteField:
+		if op.Field == "" {
+			return nil, fmt.Errorf("no field")
+		}
+		m := make(map[string]interface{})
+		if cur != nil {
+			fields, ok := cur.Data.(map[string]interface{})
+			if cur.Type != "hash" || !ok {
+				return nil, fmt.Errorf("key is a %s, not a hash", cur.Type)
+			}
+			for k, v := range fields {
+				m[k] = v
+			}
+		}
+		if op.Op == BatchSetField {
+			m[op.Field] = value
+		} else if _, ok := m[op.Field]; ok {
+			delete(m, op.Field)
+		} else {
+			return nil, fmt.Errorf("hash has no field %s", op.Field)
+		}
+		return &KeyResponse{Type: "hash", Data: m}, nil
+	case BatchAppend, BatchPrepend:
+		l := make([]interface{}, 0)
+		if cur != nil {
+			items, ok := cur.Data.([]interface{})
+			if cur.Type != "list" || !ok {
+				return nil, fmt.Errorf("key is a %s, not a list", cur.Type)
+			}
+			l = append(l, items...)
+		}
+		if op.Op == BatchAppend {
+			l = append(l, value)
+		} else {
+			l = append([]interface{}{value}, l...)
+		}
+		return &KeyResponse{Type: "list", Data: l}, nil
+	}
+	return nil, fmt.Errorf("unknown operation '%s'", op.Op)
+}
+
+// diffs are the changes of the plan, in key order
+func (p *batchPlan) diffs() []KeyDiff {
+	before := make(map[string]KeyResponse)
+	after := make(map[string]KeyResponse)
+	for _, k := range p.keys {
+		if p.before[k] != nil {
+			before[k] = *p.before[k]
+		}
+		if p.after[k] != nil {
+			after[k] = *p.after[k]
+		}
+	}
+	return DiffKeys(before, after)
+}
@@ -0,0 +1,93 @@
+package confclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBatchValidatesBeforeWriting(t *testing.T) {
+	keys := map[string]KeyResponse{
+		"pods:a:services": {"list", []interface{}{"svc1"}},
+	}
+	ts := newTreeServer(t, keys)
+	defer ts.Close()
+	c := InitiateClient(ts.URL)
+
+	_, err := c.Batch().
+		Set("svc1:pod", KeyResponse{"string", "b"}).
+		SetField("pods:a:services", "x", "y").
+		Delete("svc9:pod").
+		Commit()
+	if err == nil || !strings.Contains(err.Error(), "not a hash") || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("Expected every problem to be reported, got %v", err)
+	}
+	if _, ok := keys["svc1:pod"]; ok {
+		t.Errorf("Expected nothing to be written")
+	}
+}
+
+func TestBatchCommitAndRollback(t *testing.T) {
+	keys := map[string]KeyResponse{
+		"pods:a:services": {"list", []interface{}{"svc1", "svc2"}},
+		"pods:b:services": {"list", []interface{}{"svc3"}},
+		"svc1:pod":        {"string", "a"},
+	}
+	original := make(map[string]KeyResponse)
+	for k, v := range keys {
+		original[k] = v
+	}
+	ts := newTreeServer(t, keys)
+	defer ts.Close()
+	backend, _ := url.Parse(ts.URL)
+	proxy := httputil.NewSingleHostReverseProxy(backend)
+	front := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && r.URL.Path == "/admin/key/broken" {
+			http.Error(w, "broken", http.StatusInternalServerError)
+			return
+		}
+		proxy.ServeHTTP(w, r)
+	}))
+	defer front.Close()
+	c := InitiateClient(front.URL)
+
+	move := func(b *Batch) *Batch {
+		return b.Set("pods:a:services", KeyResponse{"list", []interface{}{"svc2"}}).
+			Append("pods:b:services", "svc1").
+			Set("svc1:pod", KeyResponse{"string", "b"})
+	}
+
+	_, err := move(c.Batch()).SetField("broken", "x", "y").Commit()
+	e, ok := err.(*BatchError)
+	if !ok || e.Key != "broken" || len(e.RolledBack) != 3 || len(e.NotRolledBack) != 0 {
+		t.Fatalf("Expected 3 keys rolled back, got %v", err)
+	}
+	if !reflect.DeepEqual(keys, original) {
+		t.Errorf("Expected %v after rollback, got %v", original, keys)
+	}
+
+	diffs, err := move(c.Batch()).Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 3 || keys["svc1:pod"].Data != "b" || len(keys["pods:b:services"].Data.([]interface{})) != 2 {
+		t.Errorf("Unexpected keys after commit: %v", keys)
+	}
+}
+
+func TestReadBatchOps(t *testing.T) {
+	ops, err := ReadBatchOps(strings.NewReader(`{"op":"set","key":"a","data":"1"}
+
+{"op":"hset","key":"b","field":"port","data":5432}
+`))
+	if err != nil || len(ops) != 2 || ops[1].Field != "port" {
+		t.Fatalf("Unexpected ops %v: %v", ops, err)
+	}
+	if _, err := ReadBatchOps(strings.NewReader("{\n")); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("Expected an error for line 1, got %v", err)
+	}
+}
@@ -17,7 +17,18 @@ type Client struct {
 	httpClient  *http.Client
 	scopeVars   map[string]string
 	TemplateDir string
+	PartialsDir string
 	ConfigDir   string
+	StateDir    string
+	Manifest    string
+	// Strict makes lookups which fall back to their default value fail
+	Strict    bool
+	recorders []*LookupRecorder
+	secretKey *[32]byte
+	// ifVersion makes admin writes conditional, see IfUnchanged
+	ifVersion string
+	// secretLookup keeps the values this client looks up out of manifests
+	secretLookup bool
 }
 
 func InitiateClient(url string) *Client {
@@ -26,6 +37,9 @@ func InitiateClient(url string) *Client {
 		httpClient: &http.Client{},
 		scopeVars:  make(map[string]string),
 	}
+	installRedactHook.Do(func() {
+		log.AddHook(RedactHook{})
+	})
 
 	// Get all environment variables beginning with CFG_
 	//  And store them in the scopeVars map
@@ -42,6 +56,20 @@ func InitiateClient(url string) *Client {
 	return client
 }
 
+// WithScope returns a copy of the client whose lookups use the given scope
+// variables on top of the ones read from CFG_ environment variables
+func (c *Client) WithScope(scope map[string]string) *Client {
+	scoped := *c
+	scoped.scopeVars = make(map[string]string)
+	for k, v := range c.scopeVars {
+		scoped.scopeVars[k] = v
+	}
+	for k, v := range scope {
+		scoped.scopeVars[strings.ToLower(k)] = v
+	}
+	return &scoped
+}
+
 func (c *Client) GetList(key string) (ListResponse, error) {
 	var resp ListResponse
 
@@ -53,6 +81,9 @@ func (c *Client) GetList(key string) (ListResponse, error) {
 	if err = json.Unmarshal(body, &resp); err != nil {
 		return resp, err
 	}
+	for _, v := range resp.Data {
+		registerLookedUpSecret(key, v.Value)
+	}
 	return resp, err
 }
 
@@ -66,6 +97,11 @@ func (c *Client) GetHash(key string) (HashResponse, error) {
 	if err = json.Unmarshal(body, &resp); err != nil {
 		return resp, err
 	}
+	for field, v := range resp.Data {
+		if IsSecretValue(key, field, v.Value) {
+			RegisterSecret(v.Value)
+		}
+	}
 
 	return resp, err
 }
@@ -82,6 +118,7 @@ func (c *Client) GetString(key string) (StringResponse, error) {
 	if err = json.Unmarshal(body, &resp); err != nil {
 		return resp, err
 	}
+	registerLookedUpSecret(key, resp.Data.Value)
 
 	return resp, err
 }
@@ -95,11 +132,17 @@ func (c *Client) GETRequestJSON(path string) ([]byte, error) {
 }
 
 func (c *Client) GETRequest(path string, accept string) ([]byte, error) {
+	body, _, err := c.getRequest(path, accept)
+	return body, err
+}
+
+// getRequest is GETRequest which also returns the response headers
+func (c *Client) getRequest(path string, accept string) ([]byte, http.Header, error) {
 	req_url := strings.Join([]string{c.url, path}, "")
 
 	req, err := http.NewRequest("GET", req_url, nil)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	req.Header.Set("Accept", accept)
 
@@ -110,25 +153,26 @@ func (c *Client) GETRequest(path string, accept string) ([]byte, error) {
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer resp.Body.Close()
 
 	l := log.WithFields(log.Fields{"url": req_url, "httpcode": resp.StatusCode, "method": "GET"})
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		l.Warn("HTTP error")
-		return nil, errors.New(fmt.Sprintf("HTTP Error %d", resp.StatusCode))
+		return nil, resp.Header, errors.New(fmt.Sprintf("HTTP Error %d", resp.StatusCode))
 	}
 	l.Debug("HTTP log")
 
-	return ioutil.ReadAll(resp.Body)
-
+	b, err := ioutil.ReadAll(resp.Body)
+	return b, resp.Header, err
 }
 
 func (c *Client) PATCHRequestJSON(path string, data []byte) ([]byte, error) {
 	req_url := strings.Join([]string{c.url, path}, "")
 	req, err := http.NewRequest("PATCH", req_url, bytes.NewBuffer(data))
 	req.Header.Set("Content-Type", "application/json")
+	c.setIfMatch(req)
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -151,6 +195,7 @@ func (c *Client) POSTRequestJSON(path string, data []byte) ([]byte, error) {
 	req_url := strings.Join([]string{c.url, path}, "")
 	req, err := http.NewRequest("POST", req_url, bytes.NewBuffer(data))
 	req.Header.Set("Content-Type", "application/json")
+	c.setIfMatch(req)
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -172,6 +217,7 @@ func (c *Client) POSTRequestJSON(path string, data []byte) ([]byte, error) {
 func (c *Client) DELETERequest(path string) ([]byte, error) {
 	req_url := strings.Join([]string{c.url, path}, "")
 	req, err := http.NewRequest("DELETE", req_url, nil)
+	c.setIfMatch(req)
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -2,12 +2,16 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
 	"flag"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"github.com/moensch/confclient"
 	"io/ioutil"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
 )
 
@@ -18,13 +22,75 @@ var (
 	configMgrUser string
 	configMgrPass string
 	logLevel      string
+	reveal        bool
+	encrypt       bool
+	recipients    string
+	secretKey     string
+	dryRun        bool
+	loadPolicy    string
+	keyPrefix     string
+	dumpFilter    string
+	workers       int
+	progress      bool
+	outputFormat  string
+	syncFrom      string
+	syncTo        string
+	syncDelete    bool
+	syncApply     bool
+	inputPath     string
+	prune         bool
+	format        string
+	noClobber     bool
+	ifUnchanged   string
 )
 
+// noKeyOps are the operations which take no key or path argument
+var noKeyOps = map[string]bool{
+	"sync":  true,
+	"apply": true,
+	"batch": true,
+}
+
+// conditionalOps are the operations which write a single key and so can be
+// made conditional with -if-unchanged
+var conditionalOps = map[string]bool{
+	"set":     true,
+	"del":     true,
+	"hset":    true,
+	"hdel":    true,
+	"rpush":   true,
+	"lpush":   true,
+	"lset":    true,
+	"linsert": true,
+	"lrem":    true,
+	"lpop":    true,
+}
+
 func init() {
 	flag.StringVar(&configMgrUser, "u", os.Getenv("CONFIGMGR_USER"), "Username")
 	flag.StringVar(&configMgrPass, "p", os.Getenv("CONFIGMGR_PASS"), "Password")
 	flag.StringVar(&configMgrUrl, "s", os.Getenv("CONFIGMGR_URL"), "Config manager URL")
 	flag.StringVar(&logLevel, "l", "error", "Log level (debug|info|warn|error)")
+	flag.BoolVar(&encrypt, "encrypt", false, "Encrypt values for -recipients before setting them (set, hset)")
+	flag.StringVar(&recipients, "recipients", os.Getenv("CONFADM_RECIPIENTS"), "Comma separated public key files to encrypt for")
+	flag.StringVar(&secretKey, "secret-key", os.Getenv("CONFADM_SECRET_KEY"), "Private key used to decrypt values (reencrypt)")
+	flag.BoolVar(&dryRun, "dry-run", false, "Show what load, import, apply or batch would do without changing anything")
+	flag.StringVar(&loadPolicy, "policy", confclient.LoadSkipExisting, "What load and import do with existing keys (overwrite|skip-existing|merge)")
+	flag.StringVar(&keyPrefix, "prefix", "", "Only load, import, diff or sync keys starting with this prefix")
+	flag.StringVar(&format, "format", "yaml", "Format for export and import ("+strings.Join(confclient.ExportFormats, "|")+"), and edit ("+strings.Join(confclient.EditFormats, "|")+")")
+	flag.StringVar(&dumpFilter, "filter", "*", "Only dump keys matching this pattern")
+	flag.IntVar(&workers, "j", 4, "Number of keys to dump in parallel")
+	flag.BoolVar(&progress, "progress", true, "Report dump progress on STDERR")
+	flag.StringVar(&outputFormat, "o", "text", "Output format for diff, sync, apply and batch (text|json)")
+	flag.StringVar(&syncFrom, "from", "", "Server URL or dump to sync from")
+	flag.StringVar(&syncTo, "to", "", "Server URL to sync to (default -s)")
+	flag.BoolVar(&syncDelete, "delete", false, "Delete keys which are not in the sync source")
+	flag.BoolVar(&syncApply, "apply", false, "Apply the sync plan or save an edited key without asking")
+	flag.StringVar(&inputPath, "f", "", "Directory of YAML/TOML desired state files for apply, or JSON lines file of operations for batch ('-' for STDIN)")
+	flag.BoolVar(&prune, "prune", false, "Delete keys below the prefixes of the desired state files which are not in them")
+	flag.BoolVar(&noClobber, "no-clobber", false, "Refuse to overwrite existing keys (cp, mv)")
+	flag.StringVar(&ifUnchanged, "if-unchanged", "", "Only write if the key still has this version, as printed by the version operation")
+	flag.BoolVar(&reveal, "reveal", false, "Print secret and encrypted values instead of "+confclient.Redacted)
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage for %s:\n", os.Args[0])
@@ -38,15 +104,47 @@ func init() {
 		fmt.Fprintf(os.Stderr, "  del <key>                   : Delete a key\n")
 		fmt.Fprintf(os.Stderr, "  list <filter>               : List matching keys (use '*' to list all)\n")
 		fmt.Fprintf(os.Stderr, "  type <key>                  : Get Key Type\n")
+		fmt.Fprintf(os.Stderr, "  version <key>               : Get the version of a key, for -if-unchanged\n")
 		fmt.Fprintf(os.Stderr, "  hget <key> <field>          : Get just one field from a hash\n")
 		fmt.Fprintf(os.Stderr, "  hset <key> <field> <value>  : Set just one field in a hash\n")
 		fmt.Fprintf(os.Stderr, "  hset <key> <field> -        : Set just one field in a hash from STDIN\n")
 		fmt.Fprintf(os.Stderr, "  hgeta <key-partial> <field> : Get every place this key field is defined\n")
 		fmt.Fprintf(os.Stderr, "  lget <key> <index>          : Get list item at position\n")
-		fmt.Fprintf(os.Stderr, "  lpush <key> <value>         : Add entry to list (or create new list if it does not exist)\n")
-		fmt.Fprintf(os.Stderr, "  lpush <key> -               : Add entry to list (or create new list if it does not exist) from STDIN\n")
+		fmt.Fprintf(os.Stderr, "  hdel <key> <field>          : Delete one field from a hash\n")
+		fmt.Fprintf(os.Stderr, "  rpush <key> <value>         : Add entry to the end of a list (or create new list if it does not exist)\n")
+		fmt.Fprintf(os.Stderr, "  lpush <key> <value>         : Add entry to the start of a list (or create new list if it does not exist)\n")
+		fmt.Fprintf(os.Stderr, "  lset <key> <index> <value>  : Replace list item at position (negative positions count from the end)\n")
+		fmt.Fprintf(os.Stderr, "  linsert <key> <index> <value> : Insert entry before list item at position\n")
+		fmt.Fprintf(os.Stderr, "  lrem <key> <value> [count]  : Remove entries equal to value (count > 0 from the start, < 0 from the end, default all)\n")
+		fmt.Fprintf(os.Stderr, "  lpop <key>                  : Remove and print the first list item\n")
+		fmt.Fprintf(os.Stderr, "                                (a <value> of - is read from STDIN)\n")
+		fmt.Fprintf(os.Stderr, "  edit <key>                  : Edit a key in $EDITOR as -format yaml or json, then save it if it was not changed meanwhile\n")
+		fmt.Fprintf(os.Stderr, "  cp <src> <dst>              : Copy a key, keeping its type\n")
+		fmt.Fprintf(os.Stderr, "  cp -r <prefix> <newprefix>  : Copy a key and every key below it\n")
+		fmt.Fprintf(os.Stderr, "  mv <src> <dst>              : Rename a key\n")
+		fmt.Fprintf(os.Stderr, "                                (cp and mv take -no-clobber to refuse to overwrite existing keys)\n")
 		fmt.Fprintf(os.Stderr, "\n")
-		fmt.Fprintf(os.Stderr, "  dump <path>                 : Dump all keys to <path> (can be loaded again with confmgr-load-defaults)\n")
+		fmt.Fprintf(os.Stderr, "  dump <path>                 : Dump all keys (or -filter) to directory <path> (can be loaded again with load)\n")
+		fmt.Fprintf(os.Stderr, "  dump <file>.jsonl[.gz]      : Dump all keys to a single archive file\n")
+		fmt.Fprintf(os.Stderr, "  dump -                      : Dump all keys as an archive to STDOUT\n")
+		fmt.Fprintf(os.Stderr, "  load <path>                 : Load all keys from a dump directory or archive (see -policy, -prefix, -dry-run)\n")
+		fmt.Fprintf(os.Stderr, "  load -                      : Load all keys from an archive on STDIN\n")
+		fmt.Fprintf(os.Stderr, "  export <filter>             : Write matching keys to STDOUT in -format (secrets redacted unless -reveal)\n")
+		fmt.Fprintf(os.Stderr, "  import <file>               : Set keys from a -format file, or STDIN with '-' (see -policy, -prefix, -dry-run)\n")
+		fmt.Fprintf(os.Stderr, "  diff <src> <dst>            : Show keys added, removed or changed from <src> to <dst>, each a server URL or dump\n")
+		fmt.Fprintf(os.Stderr, "  sync                        : Make -to match -from (see -prefix, -delete, -apply)\n")
+		fmt.Fprintf(os.Stderr, "  apply                       : Make the server match the desired state files in -f (see -prune, -dry-run)\n")
+		fmt.Fprintf(os.Stderr, "  batch                       : Apply the operations in -f as one batch, rolled back if a write fails (see -dry-run)\n")
+		fmt.Fprintf(os.Stderr, "  keygen <path>               : Write a new secret key to <path> and its public key to <path>.pub\n")
+		fmt.Fprintf(os.Stderr, "  reencrypt <filter>          : Encrypt the encrypted values of matching keys again for -recipients\n")
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "Values of keys or hash fields named like a secret (%s)\n", strings.Join(confclient.SecretKeyPatterns, ", "))
+		fmt.Fprintf(os.Stderr, "and encrypted values are printed as %s unless -reveal is given.\n", confclient.Redacted)
+		fmt.Fprintf(os.Stderr, "With -if-unchanged <version>, set, del, hset, hdel and the list operations\n")
+		fmt.Fprintf(os.Stderr, "exit with status 3 instead of writing if the key changed since its version\n")
+		fmt.Fprintf(os.Stderr, "was read.\n")
+		fmt.Fprintf(os.Stderr, "With -encrypt, set and hset encrypt values for -recipients so the server\n")
+		fmt.Fprintf(os.Stderr, "only stores ciphertext.\n")
 		fmt.Fprintf(os.Stderr, "\n\nConfig:\n")
 		flag.PrintDefaults()
 	}
@@ -56,15 +154,42 @@ func main() {
 	flag.Parse()
 	lvl, _ := log.ParseLevel(logLevel)
 	log.SetLevel(lvl)
-	if flag.NArg() < 2 {
-		log.Warnf("Not enough args. Need 2, have %s", flag.NArg())
+	if flag.NArg() < 2 && !noKeyOps[flag.Arg(0)] {
+		log.Warnf("Not enough args. Need 2, have %d", flag.NArg())
 		flag.Usage()
 		os.Exit(1)
 	}
 	operation = flag.Arg(0)
 	keyName = flag.Arg(1)
 
-	if operation == "" || configMgrUrl == "" || keyName == "" {
+	if operation == "sync" {
+		if syncTo == "" {
+			syncTo = configMgrUrl
+		}
+		if syncFrom == "" || syncTo == "" {
+			log.Fatal("ERROR: sync needs -from and -to (or -s)")
+		}
+		os.Exit(syncServers(syncFrom, syncTo))
+	}
+
+	if operation == "diff" {
+		// Both sides are server URLs or dumps, -s is not needed
+		if flag.NArg() < 3 {
+			flag.Usage()
+			os.Exit(1)
+		}
+		os.Exit(diff(flag.Arg(1), flag.Arg(2)))
+	}
+
+	if operation == "keygen" {
+		// Note: keyName is actually the path
+		if err := keygen(keyName); err != nil {
+			log.Fatalf("ERROR: %s", err)
+		}
+		os.Exit(0)
+	}
+
+	if operation == "" || configMgrUrl == "" || (keyName == "" && !noKeyOps[operation]) {
 		log.Infof("Operation: %s", operation)
 		log.Infof("configMgrUrl: %s", configMgrUrl)
 		log.Infof("keyName: %s", keyName)
@@ -72,18 +197,29 @@ func main() {
 		os.Exit(1)
 	}
 	var c = confclient.InitiateClient(configMgrUrl)
+	if secretKey != "" {
+		if err := c.LoadSecretKey(secretKey); err != nil {
+			log.Fatalf("ERROR: %s", err)
+		}
+	}
+	if ifUnchanged != "" {
+		if !conditionalOps[operation] {
+			log.Fatalf("ERROR: -if-unchanged does not apply to %s", operation)
+		}
+		c = c.IfUnchanged(ifUnchanged)
+	}
 
 	switch operation {
 	case "geta":
 		keys, err := c.AdminListKeys("*:" + keyName)
 		if err != nil {
-			log.Fatalf("ERROR: %s", err)
+			fatal(err)
 		}
 		for _, k := range keys {
 			fmt.Printf("Key: %s\n", k)
-			resp, err := c.AdminGetKeyAsTEXT(k)
+			resp, err := getText(c, k)
 			if err != nil {
-				log.Fatalf("ERROR: %s", err)
+				fatal(err)
 			}
 			fmt.Printf("%s\n", resp)
 		}
@@ -92,7 +228,7 @@ func main() {
 		last_part := sl[len(sl)-1]
 		keys, err := c.AdminListKeys("*:" + last_part)
 		if err != nil {
-			log.Fatalf("ERROR: %s", err)
+			fatal(err)
 		}
 		for _, k := range keys {
 			fmt.Printf("%s\n", k)
@@ -101,7 +237,7 @@ func main() {
 	case "list":
 		keys, err := c.AdminListKeys(keyName)
 		if err != nil {
-			log.Fatalf("ERROR: %s", err)
+			fatal(err)
 		}
 		for _, k := range keys {
 			fmt.Printf("%s\n", k)
@@ -110,7 +246,7 @@ func main() {
 	case "hlist":
 		keys, err := c.AdminListHashFields(keyName)
 		if err != nil {
-			log.Debug("ERROR: %s", err)
+			log.Debugf("ERROR: %s", err)
 		}
 		for _, k := range keys {
 			fmt.Printf("%s\n", k)
@@ -119,27 +255,41 @@ func main() {
 	case "del":
 		err := c.AdminDeleteKey(keyName)
 		if err != nil {
-			log.Fatalf("ERROR: %s", err)
+			fatal(err)
 		}
 		log.Debug("DEL OK")
 	case "gett":
-		stringresp, err := c.AdminGetKeyAsTEXT(keyName)
+		stringresp, err := getText(c, keyName)
 		if err != nil {
-			log.Fatalf("ERROR: %s", err)
+			fatal(err)
 		}
 		fmt.Printf("%s\n", stringresp)
 		log.Debug("GETT OK")
 	case "get":
-		jsonblob, err := c.AdminGetKeyAsJSON(keyName)
+		kr, err := c.AdminGetKey(keyName)
 		if err != nil {
-			log.Fatalf("ERROR: %s", err)
+			fatal(err)
+		}
+		if !reveal {
+			kr, _ = confclient.RedactKeyResponse(keyName, kr)
+		}
+		jsonblob, err := json.MarshalIndent(kr, "", "  ")
+		if err != nil {
+			fatal(err)
 		}
 		fmt.Printf("%s\n", string(jsonblob))
 		log.Print("GET OK")
+	case "version":
+		_, version, err := c.AdminGetKeyVersion(keyName)
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Printf("%s\n", version)
+		log.Debug("VERSION OK")
 	case "type":
 		ktype, err := c.AdminGetKeyType(keyName)
 		if err != nil {
-			log.Fatalf("ERROR: %s", err)
+			fatal(err)
 		}
 		fmt.Printf("%s\n", ktype)
 		log.Debug("TYPE OK")
@@ -152,26 +302,40 @@ func main() {
 				reader := bufio.NewReader(os.Stdin)
 				b, err := ioutil.ReadAll(reader)
 				if err != nil {
-					log.Fatalf("ERROR: %s", err)
+					fatal(err)
 				}
 				value = string(b)
 			}
+			value = encryptValue(value)
 			err := c.AdminSetStringKey(keyName, value)
 			if err != nil {
-				log.Fatalf("ERROR: %s", err)
+				fatal(err)
 			}
 		} else {
 			reader := bufio.NewReader(os.Stdin)
 			b, err := ioutil.ReadAll(reader)
 			if err != nil {
-				log.Fatalf("ERROR: %s", err)
+				fatal(err)
 			}
 			if len(b) == 0 {
 				log.Fatalf("ERROR: Read zero bytes")
 			}
+			if encrypt {
+				var kr confclient.KeyResponse
+				if err := json.Unmarshal(b, &kr); err != nil {
+					fatal(err)
+				}
+				kr, err = confclient.EncryptKeyResponse(kr, readRecipients())
+				if err != nil {
+					fatal(err)
+				}
+				if b, err = json.Marshal(kr); err != nil {
+					fatal(err)
+				}
+			}
 			err = c.AdminSetKeyFromJSON(keyName, b)
 			if err != nil {
-				log.Fatalf("ERROR: %s", err)
+				fatal(err)
 			}
 		}
 		log.Debug("SET OK")
@@ -183,7 +347,7 @@ func main() {
 		fieldName := flag.Arg(2)
 		keys, err := c.AdminListKeys("*:" + keyName)
 		if err != nil {
-			log.Fatalf("ERROR: %s", err)
+			fatal(err)
 		}
 
 		found := make(map[string]string)
@@ -192,14 +356,13 @@ func main() {
 			val, err := c.AdminGetHashField(k, fieldName)
 			if err != nil {
 				// Ignore 404
-				if !strings.Contains(err.Error(), "404") {
-					// This is bad, string matching an error, but it gets shit done for now
+				if !confclient.IsNotFound(err) {
 					log.Fatalf("ERROR: Cannot get key %s field %s: %s", k, fieldName, err)
 				} else {
 					continue
 				}
 			}
-			found[k] = val
+			found[k] = redactValue(k, fieldName, val)
 			if len(k) > maxlen {
 				maxlen = len(k)
 			}
@@ -216,29 +379,85 @@ func main() {
 
 		val, err := c.AdminGetHashField(keyName, fieldName)
 		if err != nil {
-			log.Fatalf("ERROR: %s", err)
+			fatal(err)
 		}
-		fmt.Printf("%s\n", val)
+		fmt.Printf("%s\n", redactValue(keyName, fieldName, val))
 		log.Debug("HGET OK")
+	case "rpush":
+		if flag.NArg() < 3 {
+			flag.Usage()
+			os.Exit(1)
+		}
+		err := c.AdminListAppend(keyName, argValue(2))
+		if err != nil {
+			fatal(err)
+		}
+		log.Debug("RPUSH OK")
 	case "lpush":
 		if flag.NArg() < 3 {
 			flag.Usage()
 			os.Exit(1)
 		}
-		stringval := flag.Arg(2)
-		if stringval == "-" {
-			// read from stdin
-			reader := bufio.NewReader(os.Stdin)
-			b, err := ioutil.ReadAll(reader)
-			if err != nil {
-				log.Fatalf("ERROR: %s", err)
+		err := c.AdminListPrepend(keyName, argValue(2))
+		if err != nil {
+			fatal(err)
+		}
+		log.Debug("LPUSH OK")
+	case "lset", "linsert":
+		if flag.NArg() < 4 {
+			flag.Usage()
+			os.Exit(1)
+		}
+		index, err := strconv.Atoi(flag.Arg(2))
+		if err != nil {
+			log.Fatalf("ERROR: Invalid list index '%s'", flag.Arg(2))
+		}
+		if operation == "lset" {
+			err = c.AdminListSet(keyName, index, argValue(3))
+		} else {
+			err = c.AdminListInsert(keyName, index, argValue(3))
+		}
+		if err != nil {
+			fatal(err)
+		}
+		log.Debugf("%s OK", strings.ToUpper(operation))
+	case "lrem":
+		if flag.NArg() < 3 {
+			flag.Usage()
+			os.Exit(1)
+		}
+		count := 0
+		if flag.NArg() >= 4 {
+			var err error
+			if count, err = strconv.Atoi(flag.Arg(3)); err != nil {
+				log.Fatalf("ERROR: Invalid count '%s'", flag.Arg(3))
 			}
-			stringval = string(b)
 		}
-		err := c.AdminListAppend(keyName, stringval)
+		removed, err := c.AdminListRemove(keyName, count, argValue(2))
 		if err != nil {
-			log.Fatalf("ERROR: %s", err)
+			fatal(err)
+		}
+		if removed == 0 {
+			log.Fatalf("ERROR: Value not found in list %s", keyName)
+		}
+		log.Debugf("LREM OK, removed %d", removed)
+	case "lpop":
+		item, err := c.AdminListPop(keyName)
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Printf("%s\n", redactValue(keyName, "", item))
+		log.Debug("LPOP OK")
+	case "hdel":
+		if flag.NArg() < 3 {
+			flag.Usage()
+			os.Exit(1)
+		}
+		err := c.AdminDeleteHashField(keyName, flag.Arg(2))
+		if err != nil {
+			fatal(err)
 		}
+		log.Debug("HDEL OK")
 	case "hset":
 		if flag.NArg() < 4 {
 			flag.Usage()
@@ -251,14 +470,15 @@ func main() {
 			reader := bufio.NewReader(os.Stdin)
 			b, err := ioutil.ReadAll(reader)
 			if err != nil {
-				log.Fatalf("ERROR: %s", err)
+				fatal(err)
 			}
 			stringval = string(b)
 		}
+		stringval = encryptValue(stringval)
 
 		err := c.AdminSetHashField(keyName, fieldName, stringval)
 		if err != nil {
-			log.Fatalf("ERROR: %s", err)
+			fatal(err)
 		}
 		log.Debug("HSET OK")
 	case "lget":
@@ -270,15 +490,492 @@ func main() {
 
 		val, err := c.AdminGetListIndex(keyName, listIndex)
 		if err != nil {
-			log.Fatalf("ERROR: %s", err)
+			fatal(err)
 		}
-		fmt.Printf("%s\n", val)
+		fmt.Printf("%s\n", redactValue(keyName, "", val))
 		log.Debug("LGET OK")
+	case "edit":
+		os.Exit(edit(c, keyName))
+	case "cp", "mv":
+		copyKeys(c, operation, flag.Args()[1:])
+		log.Debugf("%s OK", strings.ToUpper(operation))
+	case "reencrypt":
+		count, err := c.AdminReencryptKeys(keyName, readRecipients())
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Printf("Reencrypted %d keys\n", count)
+	case "batch":
+		if inputPath == "" {
+			log.Fatal("ERROR: batch needs -f <file>")
+		}
+		os.Exit(batch(c, inputPath))
+	case "apply":
+		if inputPath == "" {
+			log.Fatal("ERROR: apply needs -f <dir>")
+		}
+		os.Exit(apply(c, inputPath))
+	case "load":
+		// Note: keyName is actually the path
+		printLoadResult(c.AdminLoadKeys(keyName, loadOptions()))
+	case "export":
+		err := c.AdminExportKeys(os.Stdout, keyName, format, reveal)
+		if err != nil {
+			fatal(err)
+		}
+	case "import":
+		// Note: keyName is actually the path
+		in := os.Stdin
+		if keyName != "-" {
+			fh, err := os.Open(keyName)
+			if err != nil {
+				fatal(err)
+			}
+			defer fh.Close()
+			in = fh
+		}
+		printLoadResult(c.AdminImportKeys(in, format, loadOptions()))
 	case "dump":
 		// Note: keyName is actually the path
-		err := c.AdminDumpKeys(keyName)
+		opts := confclient.DumpOptions{
+			Filter:  dumpFilter,
+			Workers: workers,
+		}
+		if progress {
+			opts.Progress = func(done int, total int) {
+				fmt.Fprintf(os.Stderr, "\rDumped %d/%d keys", done, total)
+			}
+		}
+		var err error
+		if confclient.IsArchivePath(keyName) {
+			err = c.AdminDumpArchive(keyName, opts)
+		} else {
+			err = c.AdminDumpKeys(keyName, opts)
+		}
+		if progress {
+			fmt.Fprintf(os.Stderr, "\n")
+		}
+		if err != nil {
+			fatal(err)
+		}
+	}
+}
+
+// fatal logs err and exits, with status 3 if a write was refused because
+// the key changed (see -if-unchanged)
+func fatal(err error) {
+	if confclient.IsConflict(err) {
+		log.Errorf("ERROR: %s", err)
+		os.Exit(3)
+	}
+	log.Fatalf("ERROR: %s", err)
+}
+
+// redactValue hides a single value read from keyName (and fieldName) unless
+// -reveal was given
+func redactValue(keyName string, fieldName string, value string) string {
+	if !reveal && confclient.IsSecretValue(keyName, fieldName, value) {
+		return confclient.Redacted
+	}
+	return value
+}
+
+// getText gets a key as TEXT. Unless -reveal was given the key is also read
+// as JSON first, which registers its secret values to be redacted from the
+// text.
+func getText(c *confclient.Client, keyName string) (string, error) {
+	text, err := c.AdminGetKeyAsTEXT(keyName)
+	if err != nil || reveal {
+		return text, err
+	}
+	if _, err := c.AdminGetKey(keyName); err != nil {
+		return "", err
+	}
+	return confclient.RedactString(text), nil
+}
+
+// edit opens $VISUAL or $EDITOR (default vi) on keyName rendered in
+// -format. The edited key is validated, shown as a diff and saved unless the
+// key changed on the server in the meantime. Invalid edits can be edited
+// again. It returns the exit status.
+func edit(c *confclient.Client, keyName string) int {
+	original, version, err := c.AdminGetKeyVersion(keyName)
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+	b, err := confclient.MarshalKey(original, format)
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+
+	// The file may hold secrets, keep it in a private directory
+	dir, err := ioutil.TempDir("", "confadm-edit")
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+	path := filepath.Join(dir, confclient.KeyFileName(keyName)+"."+format)
+	if err := ioutil.WriteFile(path, b, 0600); err != nil {
+		os.RemoveAll(dir)
+		log.Fatalf("ERROR: %s", err)
+	}
+
+	var edited confclient.KeyResponse
+	for {
+		if err := runEditor(path); err != nil {
+			os.RemoveAll(dir)
+			log.Fatalf("ERROR: %s", err)
+		}
+		eb, err := ioutil.ReadFile(path)
 		if err != nil {
+			os.RemoveAll(dir)
 			log.Fatalf("ERROR: %s", err)
 		}
+		if edited, err = confclient.UnmarshalKey(eb, format); err == nil {
+			break
+		}
+		fmt.Fprintf(os.Stderr, "Invalid %s: %s\n", format, err)
+		if !confirm("Edit again?") {
+			fmt.Fprintf(os.Stderr, "Edits kept in %s\n", path)
+			return 1
+		}
+	}
+
+	diffs := confclient.DiffKeys(
+		map[string]confclient.KeyResponse{keyName: original},
+		map[string]confclient.KeyResponse{keyName: edited})
+	if len(diffs) == 0 {
+		os.RemoveAll(dir)
+		fmt.Printf("No changes\n")
+		return 0
+	}
+	printable := diffs
+	if !reveal {
+		printable = confclient.RedactDiffs(diffs)
+	}
+	fmt.Printf("%s", confclient.FormatDiffs(printable))
+	if dryRun || (!syncApply && !confirm("Save changes?")) {
+		os.RemoveAll(dir)
+		return 0
+	}
+
+	jsonblob, err := json.Marshal(edited)
+	if err == nil {
+		err = c.IfUnchanged(version).AdminSetKeyFromJSON(keyName, jsonblob)
+	}
+	if err != nil {
+		log.Errorf("ERROR: %s", err)
+		fmt.Fprintf(os.Stderr, "Edits kept in %s\n", path)
+		if confclient.IsConflict(err) {
+			return 3
+		}
+		return 1
+	}
+	os.RemoveAll(dir)
+	log.Debug("EDIT OK")
+	return 0
+}
+
+// runEditor runs $VISUAL or $EDITOR, which may include arguments, on path
+func runEditor(path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	cmd := exec.Command("sh", "-c", editor+` "$1"`, "sh", path)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Cannot run editor %s: %s", editor, err)
+	}
+	return nil
+}
+
+// copyKeys runs cp or mv with args, which may start with their own -r and
+// -no-clobber flags
+func copyKeys(c *confclient.Client, op string, args []string) {
+	fs := flag.NewFlagSet(op, flag.ExitOnError)
+	recursive := fs.Bool("r", false, "Copy a key and every key below it")
+	fs.BoolVar(&noClobber, "no-clobber", noClobber, "Refuse to overwrite existing keys")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	src, dst := fs.Arg(0), fs.Arg(1)
+
+	var err error
+	switch {
+	case op == "cp" && *recursive:
+		var count int
+		count, err = c.AdminCopyTree(src, dst, noClobber)
+		if err == nil {
+			fmt.Printf("Copied %d keys\n", count)
+		}
+	case op == "cp":
+		err = c.AdminCopyKey(src, dst, noClobber)
+	case *recursive:
+		log.Fatal("ERROR: mv does not take -r")
+	default:
+		err = c.AdminMoveKey(src, dst, noClobber)
+	}
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+}
+
+// argValue returns argument i, or STDIN if it is "-"
+func argValue(i int) string {
+	value := flag.Arg(i)
+	if value != "-" {
+		return value
+	}
+	b, err := ioutil.ReadAll(bufio.NewReader(os.Stdin))
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+	return string(b)
+}
+
+// readRecipients loads the -recipients public keys, which are required
+func readRecipients() []*[32]byte {
+	if recipients == "" {
+		log.Fatal("ERROR: No -recipients given to encrypt for")
+	}
+	keys, err := confclient.ReadRecipients(strings.Split(recipients, ","))
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+	return keys
+}
+
+// encryptValue encrypts value for -recipients if -encrypt was given
+func encryptValue(value string) string {
+	if !encrypt {
+		return value
+	}
+	enc, err := confclient.EncryptValue(value, readRecipients())
+	if err != nil {
+		log.Fatalf("ERROR: Cannot encrypt value: %s", err)
+	}
+	return enc
+}
+
+func keygen(path string) error {
+	pub, priv, err := confclient.GenerateSecretKey()
+	if err != nil {
+		return fmt.Errorf("Cannot generate key: %s", err)
+	}
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("Refusing to overwrite existing key %s", path)
+	}
+	if err := confclient.WriteKeyFile(path, priv, 0600); err != nil {
+		return fmt.Errorf("Cannot write secret key: %s", err)
+	}
+	if err := confclient.WriteKeyFile(path+".pub", pub, 0644); err != nil {
+		return fmt.Errorf("Cannot write public key: %s", err)
+	}
+	fmt.Printf("Key id: %s\n", confclient.KeyId(pub))
+	return nil
+}
+
+// diff prints the differences between two servers or dumps and returns the
+// exit status: 0 if they are the same, 1 if they differ
+func diff(src string, dst string) int {
+	diffs := confclient.DiffKeys(readKeys(src), readKeys(dst))
+	printDiffs(diffs)
+	if len(diffs) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// printDiffs prints diffs in the -o format, redacted unless -reveal was
+// given
+func printDiffs(diffs []confclient.KeyDiff) {
+	if !reveal {
+		diffs = confclient.RedactDiffs(diffs)
+	}
+	switch outputFormat {
+	case "json":
+		jsonblob, err := json.MarshalIndent(diffs, "", "  ")
+		if err != nil {
+			log.Fatalf("ERROR: %s", err)
+		}
+		fmt.Printf("%s\n", jsonblob)
+	case "text":
+		fmt.Printf("%s", confclient.FormatDiffs(diffs))
+	default:
+		log.Fatalf("ERROR: Unknown output format '%s'", outputFormat)
+	}
+}
+
+// batch applies the operations read from path, or STDIN if it is "-", as
+// one batch and prints the changes made. With -dry-run the operations are
+// only validated. It returns the exit status.
+func batch(c *confclient.Client, path string) int {
+	in := os.Stdin
+	if path != "-" {
+		fh, err := os.Open(path)
+		if err != nil {
+			log.Fatalf("ERROR: %s", err)
+		}
+		defer fh.Close()
+		in = fh
+	}
+	ops, err := confclient.ReadBatchOps(in)
+	if err != nil {
+		log.Fatalf("ERROR: Cannot read %s: %s", path, err)
+	}
+
+	b := c.Batch()
+	b.Ops = ops
+	var diffs []confclient.KeyDiff
+	if dryRun {
+		diffs, err = b.Validate()
+	} else {
+		diffs, err = b.Commit()
+	}
+	if err != nil {
+		fatal(err)
+	}
+	printDiffs(diffs)
+	log.Debug("BATCH OK")
+	return 0
+}
+
+// readKeys reads the keys starting with -prefix from a server or dump
+func readKeys(source string) map[string]confclient.KeyResponse {
+	keys, err := confclient.ReadKeys(source, confclient.DumpOptions{Filter: keyPrefix + "*", Workers: workers})
+	if err != nil {
+		log.Fatalf("ERROR: Cannot read %s: %s", source, err)
+	}
+	for k := range keys {
+		if !strings.HasPrefix(k, keyPrefix) {
+			delete(keys, k)
+		}
+	}
+	return keys
+}
+
+// syncServers prints the plan to make the keys in to match from and applies
+// it with -apply, or after asking when STDIN is a terminal. It returns the
+// exit status.
+func syncServers(from string, to string) int {
+	if !confclient.IsServerURL(to) {
+		log.Fatalf("ERROR: Can only sync to a server URL, not %s", to)
+	}
+	plan := confclient.PlanSync(readKeys(from), readKeys(to), syncDelete)
+	printPlan(plan)
+	if len(plan.Steps) == 0 {
+		return 0
+	}
+	if !syncApply && !confirm(fmt.Sprintf("Apply to %s?", to)) {
+		return 0
+	}
+	if err := confclient.InitiateClient(to).AdminApplyPlan(plan); err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+	fmt.Fprintf(os.Stderr, "Applied %d changes to %s\n", len(plan.Steps), to)
+	return 0
+}
+
+// confirm asks a yes/no question on STDERR if STDIN is a terminal, and
+// returns false otherwise
+func confirm(question string) bool {
+	fi, err := os.Stdin.Stat()
+	if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// apply makes the server match the desired state files in dir, or only
+// prints the plan with -dry-run. With -o json the plan is printed after it
+// is applied, with the status of each step. It returns the exit status.
+func apply(c *confclient.Client, dir string) int {
+	state, err := confclient.ReadStateDir(dir)
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+	plan, err := c.AdminPlanState(state, prune)
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+	if dryRun || outputFormat == "text" {
+		printPlan(plan)
+	}
+	if dryRun {
+		return 0
+	}
+
+	err = c.AdminApplyPlan(plan)
+	if outputFormat != "text" {
+		printPlan(plan)
+	}
+	if err != nil {
+		log.Errorf("ERROR: %s", err)
+		return 1
+	}
+	return 0
+}
+
+// printPlan prints a sync or apply plan as a diff and summary, or as JSON.
+// Values are left out of the JSON and redacted from the diff unless -reveal
+// was given.
+func printPlan(plan *confclient.SyncPlan) {
+	printable := *plan
+	if !reveal {
+		printable.Steps = make([]confclient.PlanStep, len(plan.Steps))
+		for i, step := range plan.Steps {
+			step.Value, step.Expected = nil, nil
+			step.Diff = confclient.RedactDiffs([]confclient.KeyDiff{step.Diff})[0]
+			printable.Steps[i] = step
+		}
+	}
+	switch outputFormat {
+	case "json":
+		jsonblob, err := json.MarshalIndent(printable, "", "  ")
+		if err != nil {
+			log.Fatalf("ERROR: %s", err)
+		}
+		fmt.Printf("%s\n", jsonblob)
+	case "text":
+		fmt.Printf("%s", confclient.FormatDiffs(printable.Diffs()))
+		fmt.Printf("Plan: %s\n", plan.Summary())
+	default:
+		log.Fatalf("ERROR: Unknown output format '%s'", outputFormat)
+	}
+}
+
+func loadOptions() confclient.LoadOptions {
+	return confclient.LoadOptions{
+		Policy: loadPolicy,
+		Prefix: keyPrefix,
+		DryRun: dryRun,
+	}
+}
+
+// printLoadResult prints what load or import did with each key, and a
+// summary
+func printLoadResult(result *confclient.LoadResult, err error) {
+	if result != nil {
+		for _, a := range result.Actions {
+			fmt.Printf("%-9s %s\n", a.Action, a.Key)
+		}
+	}
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+	if dryRun {
+		fmt.Printf("Dry run: %s\n", result.Summary())
+	} else {
+		fmt.Printf("%s\n", result.Summary())
 	}
 }
@@ -1,13 +1,14 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"github.com/moensch/confclient"
 	"os"
+	"runtime"
 	"strings"
-	"text/template"
 )
 
 var (
@@ -18,6 +19,14 @@ var (
 	verifyOutput bool
 	configDir    string
 	templateDir  string
+	partialsDir  string
+	stateDir     string
+	workers      int
+	keepGoing    bool
+	strictMode   bool
+	manifest     string
+	affectedKey  string
+	secretKey    string
 )
 
 func init() {
@@ -28,18 +37,58 @@ func init() {
 	flag.StringVar(&logLevel, "l", "info", "Log level (debug|info|warn|error)")
 	flag.StringVar(&configDir, "c", "/etc/conftpl/conf.d", "Configuration directory")
 	flag.StringVar(&templateDir, "td", "/etc/conftpl/templates", "Template directory")
+	flag.StringVar(&partialsDir, "pd", "", "Partials directory, every *.tmpl in it is parsed into each template")
+	flag.StringVar(&stateDir, "sd", "/var/lib/conftpl", "State directory")
+	flag.IntVar(&workers, "j", runtime.NumCPU(), "Number of templates to render in parallel")
+	flag.BoolVar(&strictMode, "strict", false, "Fail on keys falling back to their default and on missing map keys, and report every key looked up")
+	flag.StringVar(&manifest, "manifest", "", "Write a manifest of the keys used for each output file (dest|state)")
+	flag.StringVar(&affectedKey, "affected", "", "List output files whose manifest in the state directory uses this key, then exit")
+	flag.StringVar(&secretKey, "secret-key", "/etc/conftpl/secret.key", "Private key used to decrypt encrypted values, loaded if it exists")
+	flag.BoolVar(&keepGoing, "k", false, "Keep going after a template fails and report all failures at the end")
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "deps" {
+		deps(os.Args[2:])
+		os.Exit(0)
+	}
+
 	flag.Parse()
 	lvl, _ := log.ParseLevel(logLevel)
 	log.SetLevel(lvl)
+
+	if affectedKey != "" {
+		manifests, err := confclient.ReadManifests(confclient.ManifestDir(stateDir))
+		if err != nil {
+			log.Fatalf("ERROR: %s", err)
+		}
+		for _, m := range manifests {
+			if m.Uses(affectedKey) {
+				fmt.Printf("%s\n", m.Dest)
+			}
+		}
+		os.Exit(0)
+	}
+
 	if configMgrUrl == "" {
 		log.Fatal("configMgrUrl not set. Either set -u parameter or CONFIGMGR_URL environment variable")
 	}
 	var c = confclient.InitiateClient(configMgrUrl)
 	c.TemplateDir = templateDir
+	c.PartialsDir = partialsDir
 	c.ConfigDir = configDir
+	c.StateDir = stateDir
+	c.Strict = strictMode
+	c.Manifest = manifest
+	if _, err := os.Stat(secretKey); err == nil {
+		if err := c.LoadSecretKey(secretKey); err != nil {
+			log.Fatalf("ERROR: %s", err)
+		}
+	}
+	recorder := confclient.NewLookupRecorder()
+	if strictMode {
+		c = c.WithRecorder(recorder)
+	}
 
 	if requestKey != "" {
 		// Want a single key
@@ -51,17 +100,6 @@ func main() {
 		os.Exit(0)
 	}
 
-	myFuncMap := template.FuncMap{
-		"key":     c.GetStringValue,
-		"keyd":    c.GetStringValueDebug,
-		"list":    c.GetListValue,
-		"listj":   c.GetListValueJoined,
-		"listd":   c.GetListValueDebug,
-		"hash":    c.GetHashValue,
-		"hexists": c.HashExists,
-		"sexists": c.StringExists,
-		"lexists": c.ListExists,
-	}
 	if templateFile == "" {
 		// No template provided on command line - read config
 		templates, err := c.LoadConfigFiles()
@@ -69,19 +107,21 @@ func main() {
 			log.Fatalf("%s", err)
 		}
 
-		for _, t := range templates {
-			log.Infof("Processing %s", t.Name)
-			err := t.Process(myFuncMap)
-			if err != nil {
-				log.Fatalf("Error parsing template %s: %s", t.Src, err)
-			}
-			log.Infof("Successfully wrote: %s", t.Dest)
+		err = templates.ProcessAll(c, workers, keepGoing)
+		if strictMode {
+			fmt.Fprintf(os.Stderr, "Key lookups:\n%s", recorder.Report())
+		}
+		if err != nil {
+			log.Fatalf("%s", err)
 		}
 		os.Exit(0)
 	} else {
 		// Processing single template from command line - printing to STDOUT
 		tc := confclient.TemplateConfig{}
 		tc.Src = templateFile
+		tc.TemplateDir = templateDir
+		tc.PartialsDir = partialsDir
+		tc.Strict = strictMode
 		if verifyOutput {
 			if flag.NArg() < 1 {
 				log.Fatal("ERROR: Must provide extra params for verify command")
@@ -89,10 +129,35 @@ func main() {
 
 			tc.CheckCmd = strings.Join(flag.Args(), " ")
 		}
-		err := tc.Process(myFuncMap)
+		err := tc.Process(confclient.FuncMap(c))
+		if strictMode {
+			fmt.Fprintf(os.Stderr, "Key lookups:\n%s", recorder.Report())
+		}
 		if err != nil {
 			log.Fatalf("Error parsing template %s: %s", templateFile, err)
 		}
 		os.Exit(0)
 	}
 }
+
+// deps runs the deps subcommand with args, which take their own -td, -pd and
+// -l flags. It only parses templates, so no config manager is needed.
+func deps(args []string) {
+	fs := flag.NewFlagSet("deps", flag.ExitOnError)
+	fs.StringVar(&templateDir, "td", "/etc/conftpl/templates", "Template directory")
+	fs.StringVar(&partialsDir, "pd", "", "Partials directory, every *.tmpl in it is parsed into each template")
+	fs.StringVar(&logLevel, "l", "info", "Log level (debug|info|warn|error)")
+	fs.Parse(args)
+	lvl, _ := log.ParseLevel(logLevel)
+	log.SetLevel(lvl)
+
+	report, err := confclient.KeyDeps(templateDir, partialsDir)
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+	jsonblob, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+	fmt.Printf("%s\n", jsonblob)
+}
@@ -69,6 +69,7 @@ func (c *Client) GetListValue(key string) ([]string, error) {
 		}).Debug("Got list entry")
 		strings = append(strings, v.Value)
 	}
+	c.recordList(key, resp.Data)
 
 	return strings, err
 }
@@ -90,6 +91,7 @@ func (c *Client) GetListValueDebug(key string) ([]ValueSource, error) {
 			"source": v.Source,
 		}).Debug("Got list entry")
 	}
+	c.recordList(key, resp.Data)
 
 	return resp.Data, err
 }
@@ -123,6 +125,7 @@ func (c *Client) GetHashValue(key string) ([]KeyPair, error) {
 		}).Debug("Got hash key")
 		keypairs = append(keypairs, KeyPair{k, v.Value, v.Source})
 	}
+	c.recordHash(key, keypairs)
 	return keypairs, err
 }
 
@@ -143,6 +146,10 @@ func (c *Client) GetStringValueDebug(key string, v ...string) (ValueSource, erro
 			"key":    key,
 			"source": "DEFAULT",
 		}).Debug("Got string val")
+		c.recordString(key, defaultValue.Source, defaultValue.Value, true)
+		if c.Strict {
+			return defaultValue, strictDefaultError(key, err)
+		}
 		return defaultValue, nil
 	case err != nil:
 		log.WithFields(log.Fields{
@@ -154,6 +161,7 @@ func (c *Client) GetStringValueDebug(key string, v ...string) (ValueSource, erro
 			"key":    key,
 			"source": resp.Data.Source,
 		}).Debug("Got string val")
+		c.recordString(key, resp.Data.Source, resp.Data.Value, false)
 		return resp.Data, err
 	}
 }
@@ -186,6 +194,10 @@ func (c *Client) GetStringValue(key string, v ...string) (string, error) {
 			"key":    key,
 			"source": "DEFAULT",
 		}).Debug("Got string val")
+		c.recordString(key, "__DEFAULT__", defaultValue, true)
+		if c.Strict {
+			return defaultValue, strictDefaultError(key, err)
+		}
 		return defaultValue, nil
 	case err != nil:
 		log.WithFields(log.Fields{
@@ -197,6 +209,7 @@ func (c *Client) GetStringValue(key string, v ...string) (string, error) {
 			"key":    key,
 			"source": resp.Data.Source,
 		}).Debug("Got string val")
+		c.recordString(key, resp.Data.Source, resp.Data.Value, false)
 		return resp.Data.Value, err
 	}
 }
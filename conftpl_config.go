@@ -8,8 +8,11 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"os/user"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 	"text/template"
 )
 
@@ -18,31 +21,98 @@ type TemplateConfigFile struct {
 }
 
 type TemplateConfig struct {
-	Src      string `toml:"src"`
-	Name     string
-	Dest     string `toml:"dest"`
-	TempDest string
-	Uid      int `toml:"uid"`
-	Gid      int `toml:"gid"`
-	FileMode os.FileMode
-	Mode     string `toml:"mode"`
-	CheckCmd string `toml:"check_cmd"`
+	Src  string `toml:"src"`
+	Name string
+	// ConfigName is the config file name without its .toml extension and is
+	// what depends_on refers to
+	ConfigName string
+	Dest       string `toml:"dest"`
+	TempDest   string
+	Uid        int    `toml:"uid"`
+	Gid        int    `toml:"gid"`
+	Owner      string `toml:"owner"`
+	Group      string `toml:"group"`
+	FileMode   os.FileMode
+	Mode       string `toml:"mode"`
+	CheckCmd   string `toml:"check_cmd"`
+	// Vars is passed to the template as its data (the dot)
+	Vars map[string]interface{} `toml:"vars"`
+	// Scope overrides the CFG_ scope variables for this template's lookups
+	Scope map[string]string `toml:"scope"`
+	// TemplateDir is where include looks for files and PartialsDir holds
+	// templates parsed into every template
+	TemplateDir string
+	PartialsDir string
+	// DependsOn lists config names which must be processed successfully
+	// before this template
+	DependsOn []string `toml:"depends_on"`
+	// Foreach is a template pipeline such as `list "redis_ports"` which
+	// returns a list or hash. The template is rendered once per item, with
+	// Dest itself being a template.
+	Foreach string `toml:"foreach"`
+	// ForeachCleanup removes files generated by an earlier run whose item no
+	// longer exists. The generated files are tracked in StateDir.
+	ForeachCleanup bool `toml:"foreach_cleanup"`
+	StateDir       string
+	// Strict makes the template fail on missing map keys
+	Strict bool
+	// Manifest is where ProcessWithClient records the keys each output file
+	// was rendered from: "dest" (beside Dest), "state" (in StateDir) or ""
+	Manifest string
 }
 
+// funcMapFactory builds the template functions for a single render. If r is
+// not nil, lookups made through those functions must be recorded in it.
+type funcMapFactory func(r *LookupRecorder) map[string]interface{}
+
+// Process renders the template using the given template functions. No
+// manifest is written since lookups made through funcMap are not known.
 func (t *TemplateConfig) Process(funcMap map[string]interface{}) error {
+	return t.process(func(*LookupRecorder) map[string]interface{} {
+		return funcMap
+	}, false)
+}
+
+// ProcessWithClient renders the template using FuncMap with lookups made
+// through c in the template's scope. A manifest of the lookups is written
+// for every output file if Manifest is set.
+func (t *TemplateConfig) ProcessWithClient(c *Client) error {
+	return t.process(func(r *LookupRecorder) map[string]interface{} {
+		scoped := c.WithScope(t.Scope)
+		if r != nil {
+			scoped = scoped.WithRecorder(r)
+		}
+		return FuncMap(scoped)
+	}, t.Manifest != "")
+}
+
+func (t *TemplateConfig) process(funcMaps funcMapFactory, manifest bool) error {
+	if t.Foreach != "" {
+		return t.processForeach(funcMaps, manifest)
+	}
+	return t.render(funcMaps, manifest, t.Vars)
+}
+
+// render executes the template with data as its dot and writes the result
+// to Dest, or stdout if Dest is not set
+func (t *TemplateConfig) render(funcMaps funcMapFactory, manifest bool, data interface{}) error {
 	log.WithFields(log.Fields{"file": t.Src}).Info("Processing template")
-	tmpl, err := template.New(filepath.Base(t.Src)).Funcs(funcMap).ParseFiles(t.Src)
+	var recorder *LookupRecorder
+	if manifest && t.Dest != "" {
+		recorder = NewLookupRecorder()
+	}
+	tmpl, err := t.parseFile(t.Src, funcMaps(recorder), nil)
 	if err != nil {
 		return fmt.Errorf("Error parsing template %s: %s", t.Src, err)
 	}
 	var buffer bytes.Buffer
-	if err = tmpl.Execute(&buffer, nil); err != nil {
+	if err = tmpl.Execute(&buffer, data); err != nil {
 		return fmt.Errorf("Cannot execute template %s: %s", t.Src, err)
 	}
 
 	var tmpfile *os.File
 	if t.TempDest != "" {
-		tmpfile, err = os.OpenFile(t.TempDest, os.O_WRONLY|os.O_CREATE, t.FileMode)
+		tmpfile, err = os.OpenFile(t.TempDest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, t.FileMode)
 		if err != nil {
 			return fmt.Errorf("Cannot open temporary file %s: %s", t.TempDest, err)
 		}
@@ -81,6 +151,11 @@ func (t *TemplateConfig) Process(funcMap map[string]interface{}) error {
 		if err != nil {
 			return fmt.Errorf("Cannot rename %s to %s: %s", t.TempDest, t.Dest, err)
 		}
+		if recorder != nil {
+			if err := t.writeManifest(recorder); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
@@ -116,6 +191,18 @@ func (t *TemplateConfig) Validate() error {
 
 type TemplateConfigs []*TemplateConfig
 
+// ConfigErrors collects every problem found while loading template configs
+// so they can be reported together
+type ConfigErrors []error
+
+func (e ConfigErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
 func (c *Client) LoadConfigFiles() (TemplateConfigs, error) {
 	log.Infof("Reading config from: '%s'", c.ConfigDir)
 
@@ -124,19 +211,50 @@ func (c *Client) LoadConfigFiles() (TemplateConfigs, error) {
 	if err != nil {
 		return tcs, fmt.Errorf("Cannot open dir %s: %s", c.ConfigDir, err)
 	}
+	defer dh.Close()
 
-	files, err := dh.Readdir(-1)
+	names, err := dh.Readdirnames(-1)
 	if err != nil {
 		return tcs, fmt.Errorf("Cannot read dir %s: %s", c.ConfigDir, err)
 	}
-	for _, fi := range files {
-		tc, err := c.LoadConfigFile(filepath.Join(c.ConfigDir, fi.Name()))
+	sort.Strings(names)
+
+	var errs ConfigErrors
+	for _, name := range names {
+		path := filepath.Join(c.ConfigDir, name)
+		if filepath.Ext(name) != ".toml" {
+			log.Debugf("Skipping %s: not a .toml file", path)
+			continue
+		}
+		// Stat rather than Lstat so symlinked configs are still loaded
+		fi, err := os.Stat(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Cannot stat config file %s: %s", path, err))
+			continue
+		}
+		if !fi.Mode().IsRegular() {
+			log.Debugf("Skipping %s: not a regular file", path)
+			continue
+		}
+
+		tc, err := c.LoadConfigFile(path)
 		if err != nil {
-			return nil, fmt.Errorf("Cannot load config file %s: %s", filepath.Join(c.ConfigDir, fi.Name()), err)
+			// Errors of LoadConfigFile already name the file
+			if cerrs, ok := err.(ConfigErrors); ok {
+				errs = append(errs, cerrs...)
+			} else {
+				errs = append(errs, err)
+			}
+			continue
 		}
 		tcs = append(tcs, tc)
 	}
 
+	errs = append(errs, tcs.checkDependencies()...)
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
 	return tcs, nil
 }
 
@@ -148,23 +266,122 @@ func (c *Client) LoadConfigFile(path string) (*TemplateConfig, error) {
 		return nil, fmt.Errorf("Cannot parse config %s: %s", path, err)
 	}
 
+	var errs ConfigErrors
 	tr := tc.Tpl
 	tr.Name = tr.Src
+	tr.ConfigName = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	if tr.Owner != "" {
+		if tr.Uid != -1 {
+			errs = append(errs, fmt.Errorf("%s: only one of uid and owner may be set", path))
+		} else if uid, err := lookupUid(tr.Owner); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", path, err))
+		} else {
+			tr.Uid = uid
+		}
+	}
+	if tr.Group != "" {
+		if tr.Gid != -1 {
+			errs = append(errs, fmt.Errorf("%s: only one of gid and group may be set", path))
+		} else if gid, err := lookupGid(tr.Group); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", path, err))
+		} else {
+			tr.Gid = gid
+		}
+	}
 	if tr.Uid == -1 {
 		tr.Uid = os.Geteuid()
 	}
 	if tr.Gid == -1 {
 		tr.Gid = os.Getegid()
 	}
+	if tr.Uid < 0 {
+		errs = append(errs, fmt.Errorf("%s: invalid uid %d", path, tr.Uid))
+	}
+	if tr.Gid < 0 {
+		errs = append(errs, fmt.Errorf("%s: invalid gid %d", path, tr.Gid))
+	}
 	if tr.Mode != "" {
 		mode, err := strconv.ParseUint(tr.Mode, 0, 32)
-		if err != nil {
-			return nil, err
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("%s: cannot parse mode '%s': %s", path, tr.Mode, err))
+		case mode > 07777:
+			errs = append(errs, fmt.Errorf("%s: invalid mode '%s'", path, tr.Mode))
+		default:
+			tr.FileMode = fileMode(mode)
 		}
-		tr.FileMode = os.FileMode(mode)
 	}
-	tr.Src = filepath.Join(c.TemplateDir, tr.Src)
+	if tr.Src == "" {
+		errs = append(errs, fmt.Errorf("%s: src is not set", path))
+	} else {
+		tr.Src = filepath.Join(c.TemplateDir, tr.Src)
+		if _, err := os.Stat(tr.Src); err != nil {
+			errs = append(errs, fmt.Errorf("%s: src %s does not exist", path, tr.Src))
+		}
+	}
+	if !filepath.IsAbs(tr.Dest) {
+		errs = append(errs, fmt.Errorf("%s: dest '%s' must be an absolute path", path, tr.Dest))
+	}
 	tr.TempDest = fmt.Sprintf("%s.tmp", tr.Dest)
+	tr.TemplateDir = c.TemplateDir
+	tr.PartialsDir = c.PartialsDir
+	tr.StateDir = c.StateDir
+	tr.Strict = c.Strict
+	tr.Manifest = c.Manifest
+	switch {
+	case tr.Manifest != "" && tr.Manifest != ManifestDest && tr.Manifest != ManifestState:
+		errs = append(errs, fmt.Errorf("%s: invalid manifest location '%s'", path, tr.Manifest))
+	case tr.Manifest == ManifestState && tr.StateDir == "":
+		errs = append(errs, fmt.Errorf("%s: manifests in the state directory require a state directory", path))
+	}
+	for _, err := range tr.checkForeach() {
+		errs = append(errs, fmt.Errorf("%s: %s", path, err))
+	}
 
+	if len(errs) > 0 {
+		return nil, errs
+	}
 	return &tr, nil
 }
+
+// fileMode turns a Unix mode such as 04755 into an os.FileMode, whose
+// setuid, setgid and sticky bits are not the Unix ones
+func fileMode(mode uint64) os.FileMode {
+	fm := os.FileMode(mode & 0777)
+	if mode&04000 != 0 {
+		fm |= os.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		fm |= os.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		fm |= os.ModeSticky
+	}
+	return fm
+}
+
+// lookupUid resolves a user name to its numeric uid
+func lookupUid(name string) (int, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return -1, fmt.Errorf("cannot resolve owner '%s': %s", name, err)
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return -1, fmt.Errorf("owner '%s' has non-numeric uid '%s'", name, u.Uid)
+	}
+	return uid, nil
+}
+
+// lookupGid resolves a group name to its numeric gid
+func lookupGid(name string) (int, error) {
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return -1, fmt.Errorf("cannot resolve group '%s': %s", name, err)
+	}
+	gid, err := strconv.Atoi(g.Gid)
+	if err != nil {
+		return -1, fmt.Errorf("group '%s' has non-numeric gid '%s'", name, g.Gid)
+	}
+	return gid, nil
+}
@@ -0,0 +1,128 @@
+package confclient
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, path string, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Cannot write %s: %s", path, err)
+	}
+}
+
+func setupConfigDirs(t *testing.T) (*Client, string) {
+	base, err := ioutil.TempDir("", "conftpl")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %s", err)
+	}
+	c := InitiateClient("http://localhost:8080")
+	c.ConfigDir = filepath.Join(base, "conf.d")
+	c.TemplateDir = filepath.Join(base, "templates")
+	os.MkdirAll(c.ConfigDir, 0755)
+	os.MkdirAll(c.TemplateDir, 0755)
+	writeTestFile(t, filepath.Join(c.TemplateDir, "a.tmpl"), "a")
+	writeTestFile(t, filepath.Join(c.TemplateDir, "b.tmpl"), "b")
+
+	return c, base
+}
+
+func TestLoadConfigFilesFiltersAndSorts(t *testing.T) {
+	c, base := setupConfigDirs(t)
+	defer os.RemoveAll(base)
+
+	writeTestFile(t, filepath.Join(c.ConfigDir, "20-b.toml"), "[template]\nsrc = \"b.tmpl\"\ndest = \"/tmp/b\"\n")
+	writeTestFile(t, filepath.Join(c.ConfigDir, "10-a.toml"), "[template]\nsrc = \"a.tmpl\"\ndest = \"/tmp/a\"\nmode = \"0644\"\n")
+	writeTestFile(t, filepath.Join(c.ConfigDir, "10-a.toml~"), "garbage")
+	writeTestFile(t, filepath.Join(c.ConfigDir, "10-a.toml.rpmnew"), "garbage")
+	os.Mkdir(filepath.Join(c.ConfigDir, "subdir.toml"), 0755)
+
+	tcs, err := c.LoadConfigFiles()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(tcs) != 2 {
+		t.Fatalf("Expected 2 configs, got %d", len(tcs))
+	}
+	if tcs[0].Name != "a.tmpl" || tcs[1].Name != "b.tmpl" {
+		t.Errorf("Configs not in lexical order: %s, %s", tcs[0].Name, tcs[1].Name)
+	}
+	if tcs[0].FileMode != 0644 {
+		t.Errorf("Expected mode 0644, got %o", tcs[0].FileMode)
+	}
+}
+
+func TestLoadConfigFilesReportsAllErrors(t *testing.T) {
+	c, base := setupConfigDirs(t)
+	defer os.RemoveAll(base)
+
+	writeTestFile(t, filepath.Join(c.ConfigDir, "a.toml"), "[template]\nsrc = \"missing.tmpl\"\ndest = \"relative/path\"\nuid = -5\nmode = \"rwx\"\n")
+	writeTestFile(t, filepath.Join(c.ConfigDir, "b.toml"), "[template]\nsrc = \"b.tmpl\"\ndest = \"/tmp/b\"\ngid = -2\n")
+
+	_, err := c.LoadConfigFiles()
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+	errs, ok := err.(ConfigErrors)
+	if !ok {
+		t.Fatalf("Expected ConfigErrors, got %T: %s", err, err)
+	}
+	if len(errs) != 5 {
+		t.Errorf("Expected 5 errors, got %d:\n%s", len(errs), err)
+	}
+	for _, want := range []string{"does not exist", "absolute", "invalid uid", "invalid gid", "cannot parse mode"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to mention '%s':\n%s", want, err)
+		}
+	}
+}
+
+func TestLoadConfigFileModeAndParseError(t *testing.T) {
+	c, base := setupConfigDirs(t)
+	defer os.RemoveAll(base)
+
+	path := filepath.Join(c.ConfigDir, "a.toml")
+	writeTestFile(t, path, "[template]\nsrc = \"a.tmpl\"\ndest = \"/tmp/a\"\nmode = \"02750\"\n")
+	tc, err := c.LoadConfigFile(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if tc.FileMode != os.ModeSetgid|0750 {
+		t.Errorf("Expected setgid and 0750, got %s", tc.FileMode)
+	}
+
+	writeTestFile(t, path, "[template\n")
+	_, err = c.LoadConfigFiles()
+	if err == nil || strings.Count(err.Error(), path) != 1 {
+		t.Errorf("Expected the file to be named once, got: %v", err)
+	}
+}
+
+func TestLoadConfigFileOwnerGroup(t *testing.T) {
+	c, base := setupConfigDirs(t)
+	defer os.RemoveAll(base)
+
+	path := filepath.Join(c.ConfigDir, "a.toml")
+	writeTestFile(t, path, "[template]\nsrc = \"a.tmpl\"\ndest = \"/tmp/a\"\nowner = \"root\"\ngroup = \"root\"\n")
+	tc, err := c.LoadConfigFile(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if tc.Uid != 0 || tc.Gid != 0 {
+		t.Errorf("Expected root:root to resolve to 0:0, got %d:%d", tc.Uid, tc.Gid)
+	}
+
+	writeTestFile(t, path, "[template]\nsrc = \"a.tmpl\"\ndest = \"/tmp/a\"\nowner = \"no-such-user-xyz\"\ngroup = \"no-such-group-xyz\"\n")
+	_, err = c.LoadConfigFile(path)
+	if err == nil {
+		t.Fatal("Expected an error for unknown owner and group")
+	}
+	for _, want := range []string{"owner 'no-such-user-xyz'", "group 'no-such-group-xyz'"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to mention %s:\n%s", want, err)
+		}
+	}
+}
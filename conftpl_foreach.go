@@ -0,0 +1,242 @@
+package confclient
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// ForeachItem is one entry of the list or hash a foreach template iterates.
+// Key is the list index or hash field.
+type ForeachItem struct {
+	Key   interface{}
+	Value interface{}
+}
+
+// checkForeach validates the foreach related settings of a loaded config
+func (t *TemplateConfig) checkForeach() []error {
+	var errs []error
+	if t.Foreach == "" {
+		if t.ForeachCleanup {
+			errs = append(errs, fmt.Errorf("foreach_cleanup is set without foreach"))
+		}
+		return errs
+	}
+	if _, err := template.New("foreach").Funcs(foreachParseFuncs).Parse("{{" + t.Foreach + "}}"); err != nil {
+		errs = append(errs, fmt.Errorf("cannot parse foreach '%s': %s", t.Foreach, err))
+	}
+	if !strings.Contains(t.Dest, "{{") {
+		errs = append(errs, fmt.Errorf("dest '%s' must be a template when foreach is set, e.g. /etc/app/{{.Item.Key}}.conf", t.Dest))
+	} else if _, err := template.New("dest").Funcs(foreachParseFuncs).Parse(t.Dest); err != nil {
+		errs = append(errs, fmt.Errorf("cannot parse dest '%s': %s", t.Dest, err))
+	}
+	if t.ForeachCleanup && t.StateDir == "" {
+		errs = append(errs, fmt.Errorf("foreach_cleanup requires a state directory"))
+	}
+	return errs
+}
+
+// foreachParseFuncs lets foreach and dest be parsed at load time, before a
+// client is available
+var foreachParseFuncs = parseFuncMap()
+
+// evalPipeline evaluates a template pipeline such as `list "ports"` and
+// returns its value rather than its string representation
+func evalPipeline(expr string, funcMap map[string]interface{}, data interface{}) (interface{}, error) {
+	var result interface{}
+	funcs := template.FuncMap{}
+	for name, f := range funcMap {
+		funcs[name] = f
+	}
+	funcs["captureValue"] = func(v interface{}) string {
+		result = v
+		return ""
+	}
+	tmpl, err := template.New("foreach").Funcs(funcs).Parse("{{captureValue (" + expr + ")}}")
+	if err != nil {
+		return nil, err
+	}
+	if err := tmpl.Execute(ioutil.Discard, data); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// foreachItems turns the result of the foreach pipeline into a list of items
+func foreachItems(v interface{}) ([]ForeachItem, error) {
+	var items []ForeachItem
+	switch l := v.(type) {
+	case []KeyPair:
+		for _, kp := range sortHash(l) {
+			items = append(items, ForeachItem{kp.Key, kp.Value})
+		}
+		return items, nil
+	case []ValueSource:
+		for i, vs := range l {
+			items = append(items, ForeachItem{i, vs.Value})
+		}
+		return items, nil
+	case []KeyValue:
+		for _, kv := range l {
+			items = append(items, ForeachItem{kv.Name, kv.Value})
+		}
+		return items, nil
+	case nil:
+		return items, nil
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			items = append(items, ForeachItem{i, rv.Index(i).Interface()})
+		}
+	case reflect.Map:
+		keys := make(map[string]reflect.Value)
+		names := make([]string, 0, rv.Len())
+		for _, k := range rv.MapKeys() {
+			name := fmt.Sprint(k.Interface())
+			keys[name] = k
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			items = append(items, ForeachItem{keys[name].Interface(), rv.MapIndex(keys[name]).Interface()})
+		}
+	default:
+		return nil, fmt.Errorf("foreach must return a list or hash, got %T", v)
+	}
+	return items, nil
+}
+
+// processForeach renders the template once per foreach item
+func (t *TemplateConfig) processForeach(funcMaps funcMapFactory, manifest bool) error {
+	// Keys looked up by foreach and dest end up in the manifest of each item
+	var recorder *LookupRecorder
+	if manifest {
+		recorder = NewLookupRecorder()
+	}
+	itemFuncMaps := func(r *LookupRecorder) map[string]interface{} {
+		if r != nil && recorder != nil {
+			for _, l := range recorder.Lookups() {
+				r.add(l)
+			}
+		}
+		return funcMaps(r)
+	}
+
+	funcMap := funcMaps(recorder)
+	v, err := evalPipeline(t.Foreach, funcMap, t.Vars)
+	if err != nil {
+		return fmt.Errorf("Cannot evaluate foreach for %s: %s", t.Src, err)
+	}
+	items, err := foreachItems(v)
+	if err != nil {
+		return fmt.Errorf("Cannot evaluate foreach for %s: %s", t.Src, err)
+	}
+
+	destTmpl, err := template.New("dest").Funcs(funcMap).Parse(t.Dest)
+	if err != nil {
+		return fmt.Errorf("Cannot parse dest %s: %s", t.Dest, err)
+	}
+
+	written := make(map[string]bool)
+	var dests []string
+	for _, item := range items {
+		// Each item sees the static vars plus the item itself
+		data := make(map[string]interface{})
+		for k, v := range t.Vars {
+			data[k] = v
+		}
+		data["Item"] = item
+
+		var buffer bytes.Buffer
+		if err := destTmpl.Execute(&buffer, data); err != nil {
+			return fmt.Errorf("Cannot render dest %s for item %v: %s", t.Dest, item.Key, err)
+		}
+		dest := filepath.Clean(buffer.String())
+		if !filepath.IsAbs(dest) {
+			return fmt.Errorf("dest %s for item %v is not an absolute path", dest, item.Key)
+		}
+		if written[dest] {
+			return fmt.Errorf("dest %s rendered for more than one item", dest)
+		}
+
+		// Per item destinations such as systemd drop-in directories may not
+		// exist yet
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return fmt.Errorf("Cannot create directory for %s: %s", dest, err)
+		}
+		single := *t
+		single.Dest = dest
+		single.TempDest = fmt.Sprintf("%s.tmp", dest)
+		if err := single.render(itemFuncMaps, manifest, data); err != nil {
+			return err
+		}
+		written[dest] = true
+		dests = append(dests, dest)
+		log.Infof("Wrote %s for item %v", dest, item.Key)
+	}
+
+	if t.ForeachCleanup {
+		return t.cleanupForeach(dests)
+	}
+	return nil
+}
+
+func (t *TemplateConfig) foreachStateFile() string {
+	return filepath.Join(t.StateDir, t.ConfigName+".foreach")
+}
+
+// cleanupForeach removes files listed in the previous state file which were
+// not generated this time, then records the current set of files
+func (t *TemplateConfig) cleanupForeach(dests []string) error {
+	current := make(map[string]bool)
+	for _, d := range dests {
+		current[d] = true
+	}
+
+	stateFile := t.foreachStateFile()
+	if fh, err := os.Open(stateFile); err == nil {
+		scanner := bufio.NewScanner(fh)
+		for scanner.Scan() {
+			old := strings.TrimSpace(scanner.Text())
+			if old == "" || current[old] {
+				continue
+			}
+			log.Infof("Removing stale generated file %s", old)
+			if err := os.Remove(old); err != nil && !os.IsNotExist(err) {
+				fh.Close()
+				return fmt.Errorf("Cannot remove stale file %s: %s", old, err)
+			}
+		}
+		fh.Close()
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("Cannot read state file %s: %s", stateFile, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("Cannot read state file %s: %s", stateFile, err)
+	}
+
+	if err := os.MkdirAll(t.StateDir, 0755); err != nil {
+		return fmt.Errorf("Cannot create state directory %s: %s", t.StateDir, err)
+	}
+	sort.Strings(dests)
+	content := strings.Join(dests, "\n")
+	if len(dests) > 0 {
+		content += "\n"
+	}
+	tmp := stateFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(content), 0644); err != nil {
+		return fmt.Errorf("Cannot write state file %s: %s", tmp, err)
+	}
+	return os.Rename(tmp, stateFile)
+}
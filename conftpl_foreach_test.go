@@ -0,0 +1,116 @@
+package confclient
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestForeachWithCleanup(t *testing.T) {
+	c, base := setupConfigDirs(t)
+	defer os.RemoveAll(base)
+	out := filepath.Join(base, "out")
+	os.Mkdir(out, 0755)
+	c.StateDir = filepath.Join(base, "state")
+
+	writeTestFile(t, filepath.Join(c.TemplateDir, "redis.tmpl"), "port {{.Item.Value}} ({{.role}})")
+	config := filepath.Join(c.ConfigDir, "redis.toml")
+	writeConfig := func(ports string) {
+		writeTestFile(t, config, "[template]\nsrc = \"redis.tmpl\"\ndest = \""+out+"/redis-{{.Item.Value}}.conf\"\nmode = \"0644\"\nforeach = \".ports\"\nforeach_cleanup = true\n[template.vars]\nrole = \"cache\"\nports = ["+ports+"]\n")
+	}
+
+	writeConfig("6379, 6380")
+	tc, err := c.LoadConfigFile(config)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if err := tc.Process(helperFuncs); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(out, "redis-6380.conf"))
+	if err != nil || string(b) != "port 6380 (cache)" {
+		t.Errorf("Unexpected output %q: %v", b, err)
+	}
+
+	writeConfig("6380")
+	tc, err = c.LoadConfigFile(config)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if err := tc.Process(helperFuncs); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "redis-6379.conf")); !os.IsNotExist(err) {
+		t.Errorf("Expected stale redis-6379.conf to be removed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "redis-6380.conf")); err != nil {
+		t.Errorf("Expected redis-6380.conf to remain: %s", err)
+	}
+}
+
+func TestForeachConfigErrors(t *testing.T) {
+	c, base := setupConfigDirs(t)
+	defer os.RemoveAll(base)
+
+	config := filepath.Join(c.ConfigDir, "a.toml")
+	writeTestFile(t, config, "[template]\nsrc = \"a.tmpl\"\ndest = \"/tmp/static.conf\"\nforeach = \"list (\"\nforeach_cleanup = true\n")
+	_, err := c.LoadConfigFile(config)
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+	for _, want := range []string{"cannot parse foreach", "must be a template", "requires a state directory"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to mention %q:\n%s", want, err)
+		}
+	}
+}
+
+func TestForeachManifestRecordsForeachKeys(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/list/ports" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"type":"list","data":[{"value":"6379","source":"global:ports"},{"value":"6380","source":"global:ports"}]}`))
+	}))
+	defer ts.Close()
+
+	base, err := ioutil.TempDir("", "conftpl")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %s", err)
+	}
+	defer os.RemoveAll(base)
+	writeTestFile(t, filepath.Join(base, "redis.tmpl"), "port {{.Item.Value}}")
+
+	tc := &TemplateConfig{
+		Src:        filepath.Join(base, "redis.tmpl"),
+		ConfigName: "redis",
+		Dest:       base + "/redis-{{.Item.Value}}.conf",
+		Foreach:    `list "ports"`,
+		FileMode:   0644,
+		Uid:        os.Geteuid(),
+		Gid:        os.Getegid(),
+		StateDir:   filepath.Join(base, "state"),
+		Manifest:   ManifestState,
+	}
+	if err := tc.ProcessWithClient(InitiateClient(ts.URL)); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	manifests, err := ReadManifests(ManifestDir(tc.StateDir))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(manifests) != 2 {
+		t.Fatalf("Expected a manifest per item, got %+v", manifests)
+	}
+	for _, m := range manifests {
+		if !m.Uses("global:ports") {
+			t.Errorf("Expected %s to record the foreach lookup: %+v", m.Dest, m.Lookups)
+		}
+	}
+}
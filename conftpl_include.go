@@ -0,0 +1,171 @@
+package confclient
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// parseFile parses src together with every *.tmpl file in PartialsDir, so
+// that {{template "name" .}} can refer to templates defined in any of them.
+// It also provides the include function, which renders another file
+// relative to TemplateDir and returns its output.
+//
+// stack holds the files currently being included and is used to detect
+// include cycles.
+func (t *TemplateConfig) parseFile(src string, funcMap map[string]interface{}, stack []string) (*template.Template, error) {
+	templateDir := t.TemplateDir
+	partialsDir := t.PartialsDir
+	stack = append(append([]string{}, stack...), src)
+
+	funcs := template.FuncMap{}
+	for name, f := range funcMap {
+		funcs[name] = f
+	}
+	funcs["include"] = func(name string, data ...interface{}) (string, error) {
+		path := filepath.Join(templateDir, name)
+		if rel, err := filepath.Rel(templateDir, path); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", fmt.Errorf("cannot include %s: outside template directory %s", name, templateDir)
+		}
+		for _, s := range stack {
+			if s == path {
+				return "", fmt.Errorf("include cycle: %s -> %s", strings.Join(stack, " -> "), path)
+			}
+		}
+		if _, err := os.Stat(path); err != nil {
+			return "", fmt.Errorf("cannot include %s: not found in template directory %s", name, templateDir)
+		}
+		inc, err := t.parseFile(path, funcMap, stack)
+		if err != nil {
+			return "", err
+		}
+		var dot interface{}
+		if len(data) > 0 {
+			dot = data[0]
+		}
+		var buffer bytes.Buffer
+		if err := inc.Execute(&buffer, dot); err != nil {
+			return "", err
+		}
+		return buffer.String(), nil
+	}
+
+	tmpl := template.New(filepath.Base(src)).Funcs(funcs)
+	if t.Strict {
+		tmpl.Option("missingkey=error")
+	}
+	if partialsDir != "" {
+		if _, err := os.Stat(partialsDir); err != nil {
+			return nil, fmt.Errorf("Cannot read partials directory: %s", err)
+		}
+		partials, err := filepath.Glob(filepath.Join(partialsDir, "*.tmpl"))
+		if err != nil {
+			return nil, err
+		}
+		if len(partials) > 0 {
+			if _, err := tmpl.ParseFiles(partials...); err != nil {
+				return nil, fmt.Errorf("Error parsing partials in %s: %s", partialsDir, err)
+			}
+		}
+	}
+	// Parsed last so definitions in src take precedence over partials
+	if _, err := tmpl.ParseFiles(src); err != nil {
+		return nil, err
+	}
+
+	if err := checkTemplateRefs(tmpl, partialsDir); err != nil {
+		return nil, fmt.Errorf("%s: %s", src, err)
+	}
+	return tmpl, nil
+}
+
+// checkTemplateRefs makes sure every {{template "name"}} refers to a defined
+// template. Templates may invoke themselves or each other recursively, like
+// text/template allows; only include cycles are errors.
+func checkTemplateRefs(tmpl *template.Template, partialsDir string) error {
+	refs := make(map[string][]string)
+	for _, t := range tmpl.Templates() {
+		if t.Tree == nil || t.Tree.Root == nil {
+			continue
+		}
+		walkNodes(t.Tree.Root, func(n parse.Node) {
+			if tn, ok := n.(*parse.TemplateNode); ok {
+				refs[t.Name()] = append(refs[t.Name()], tn.Name)
+			}
+		})
+	}
+
+	names := make([]string, 0, len(refs))
+	for name := range refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, ref := range refs[name] {
+			if t := tmpl.Lookup(ref); t == nil || t.Tree == nil {
+				if partialsDir == "" {
+					return fmt.Errorf("template %q refers to undefined template %q (no partials directory configured)", name, ref)
+				}
+				return fmt.Errorf("template %q refers to undefined template %q (not defined in any partial in %s)", name, ref, partialsDir)
+			}
+		}
+	}
+
+	return nil
+}
+
+// walkNodes calls fn for n and every node below it
+func walkNodes(n parse.Node, fn func(parse.Node)) {
+	switch n := n.(type) {
+	case nil:
+		return
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+	}
+	fn(n)
+	switch n := n.(type) {
+	case *parse.ListNode:
+		for _, child := range n.Nodes {
+			walkNodes(child, fn)
+		}
+	case *parse.ActionNode:
+		walkNodes(n.Pipe, fn)
+	case *parse.PipeNode:
+		for _, cmd := range n.Cmds {
+			walkNodes(cmd, fn)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			walkNodes(arg, fn)
+		}
+	case *parse.IfNode:
+		walkNodes(n.Pipe, fn)
+		walkNodes(n.List, fn)
+		walkNodes(n.ElseList, fn)
+	case *parse.RangeNode:
+		walkNodes(n.Pipe, fn)
+		walkNodes(n.List, fn)
+		walkNodes(n.ElseList, fn)
+	case *parse.WithNode:
+		walkNodes(n.Pipe, fn)
+		walkNodes(n.List, fn)
+		walkNodes(n.ElseList, fn)
+	case *parse.TemplateNode:
+		walkNodes(n.Pipe, fn)
+	case *parse.ChainNode:
+		// e.g. (key "x").Field
+		walkNodes(n.Node, fn)
+	}
+}
@@ -0,0 +1,99 @@
+package confclient
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func processToString(t *testing.T, tc *TemplateConfig) (string, error) {
+	tc.Dest = filepath.Join(filepath.Dir(tc.TemplateDir), "out")
+	tc.TempDest = tc.Dest + ".tmp"
+	tc.FileMode = 0644
+	tc.Uid = os.Geteuid()
+	tc.Gid = os.Getegid()
+	if err := tc.Process(helperFuncs); err != nil {
+		return "", err
+	}
+	b, err := ioutil.ReadFile(tc.Dest)
+	if err != nil {
+		t.Fatalf("Cannot read output: %s", err)
+	}
+	return string(b), nil
+}
+
+func TestIncludesAndPartials(t *testing.T) {
+	c, base := setupConfigDirs(t)
+	defer os.RemoveAll(base)
+	partials := filepath.Join(base, "partials")
+	os.Mkdir(partials, 0755)
+	os.Mkdir(filepath.Join(c.TemplateDir, "common"), 0755)
+
+	writeTestFile(t, filepath.Join(partials, "upstream.tmpl"), `{{define "upstream"}}upstream {{.name}};{{end}}`)
+	writeTestFile(t, filepath.Join(c.TemplateDir, "common", "logging.tmpl"), `log {{.level | upper}};`)
+	writeTestFile(t, filepath.Join(c.TemplateDir, "main.tmpl"), `{{template "upstream" .}} {{include "common/logging.tmpl" .}}`)
+
+	tc := &TemplateConfig{
+		Src:         filepath.Join(c.TemplateDir, "main.tmpl"),
+		TemplateDir: c.TemplateDir,
+		PartialsDir: partials,
+		Vars:        map[string]interface{}{"name": "app", "level": "warn"},
+	}
+	out, err := processToString(t, tc)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if out != "upstream app; log WARN;" {
+		t.Errorf("Unexpected output: %q", out)
+	}
+}
+
+func TestIncludeErrors(t *testing.T) {
+	c, base := setupConfigDirs(t)
+	defer os.RemoveAll(base)
+
+	writeTestFile(t, filepath.Join(c.TemplateDir, "loop1.tmpl"), `{{include "loop2.tmpl"}}`)
+	writeTestFile(t, filepath.Join(c.TemplateDir, "loop2.tmpl"), `{{include "loop1.tmpl"}}`)
+	writeTestFile(t, filepath.Join(c.TemplateDir, "missing.tmpl"), `{{template "nope" .}}`)
+	writeTestFile(t, filepath.Join(c.TemplateDir, "noinc.tmpl"), `{{include "nope.tmpl"}}`)
+	writeTestFile(t, filepath.Join(c.TemplateDir, "escape.tmpl"), `{{include "../../etc/passwd"}}`)
+
+	tests := map[string]string{
+		"loop1.tmpl":   "include cycle",
+		"missing.tmpl": `undefined template "nope"`,
+		"noinc.tmpl":   "cannot include nope.tmpl",
+		"escape.tmpl":  "outside template directory",
+	}
+	for src, want := range tests {
+		tc := &TemplateConfig{Src: filepath.Join(c.TemplateDir, src), TemplateDir: c.TemplateDir}
+		_, err := processToString(t, tc)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: expected error containing %q, got: %v", src, want, err)
+		}
+	}
+}
+
+func TestRecursiveTemplate(t *testing.T) {
+	c, base := setupConfigDirs(t)
+	defer os.RemoveAll(base)
+
+	writeTestFile(t, filepath.Join(c.TemplateDir, "tree.tmpl"),
+		`{{define "node"}}{{.name}}({{range .children}}{{template "node" .}}{{end}}){{end}}{{template "node" .root}}`)
+	tc := &TemplateConfig{
+		Src:         filepath.Join(c.TemplateDir, "tree.tmpl"),
+		TemplateDir: c.TemplateDir,
+		Vars: map[string]interface{}{"root": map[string]interface{}{
+			"name":     "a",
+			"children": []interface{}{map[string]interface{}{"name": "b"}},
+		}},
+	}
+	out, err := processToString(t, tc)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if out != "a(b())" {
+		t.Errorf("Unexpected output: %q", out)
+	}
+}
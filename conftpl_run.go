@@ -0,0 +1,199 @@
+package confclient
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"sort"
+	"strings"
+)
+
+// ProcessFailure records why a single template was not written
+type ProcessFailure struct {
+	Template *TemplateConfig
+	Err      error
+}
+
+// ProcessErrors summarizes every template which failed or was skipped
+type ProcessErrors []ProcessFailure
+
+func (e ProcessErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, f := range e {
+		msgs[i] = fmt.Sprintf("%s (%s): %s", f.Template.ConfigName, f.Template.Name, f.Err)
+	}
+	return fmt.Sprintf("%d template(s) failed:\n%s", len(e), strings.Join(msgs, "\n"))
+}
+
+// checkDependencies makes sure every depends_on entry refers to a loaded
+// config and that the dependencies do not form a cycle
+func (tcs TemplateConfigs) checkDependencies() ConfigErrors {
+	var errs ConfigErrors
+	byName := make(map[string]*TemplateConfig)
+	for _, t := range tcs {
+		byName[t.ConfigName] = t
+	}
+	for _, t := range tcs {
+		for _, dep := range t.DependsOn {
+			if _, ok := byName[dep]; !ok {
+				errs = append(errs, fmt.Errorf("%s: depends_on refers to unknown config '%s'", t.ConfigName, dep))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	// Depth first search, reporting each cycle once
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int)
+	var visit func(t *TemplateConfig, path []string)
+	visit = func(t *TemplateConfig, path []string) {
+		state[t.ConfigName] = visiting
+		path = append(path, t.ConfigName)
+		for _, dep := range t.DependsOn {
+			switch state[dep] {
+			case visiting:
+				errs = append(errs, fmt.Errorf("%s: dependency cycle: %s -> %s", t.ConfigName, strings.Join(path, " -> "), dep))
+			case unvisited:
+				visit(byName[dep], path)
+			}
+		}
+		state[t.ConfigName] = done
+	}
+	for _, t := range tcs {
+		if state[t.ConfigName] == unvisited {
+			visit(t, nil)
+		}
+	}
+	return errs
+}
+
+type processResult struct {
+	template *TemplateConfig
+	err      error
+}
+
+// ProcessAll renders all templates with ProcessWithClient using up to
+// workers goroutines. A template is only started once everything it depends
+// on was written successfully.
+//
+// Without continueOnError no new templates are started after the first
+// failure. With it, everything not depending on a failed template is still
+// rendered. Either way the returned ProcessErrors lists every template which
+// failed or was skipped. Unknown or cyclic dependencies are returned as
+// ConfigErrors before anything is rendered.
+func (tcs TemplateConfigs) ProcessAll(c *Client, workers int, continueOnError bool) error {
+	if errs := tcs.checkDependencies(); len(errs) > 0 {
+		return errs
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	pending := make(map[string]int)
+	dependents := make(map[string][]*TemplateConfig)
+	var ready []*TemplateConfig
+	for _, t := range tcs {
+		pending[t.ConfigName] = len(t.DependsOn)
+		for _, dep := range t.DependsOn {
+			dependents[dep] = append(dependents[dep], t)
+		}
+		if len(t.DependsOn) == 0 {
+			ready = append(ready, t)
+		}
+	}
+
+	jobs := make(chan *TemplateConfig)
+	results := make(chan processResult)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for t := range jobs {
+				log.Infof("Processing %s", t.Name)
+				results <- processResult{t, t.ProcessWithClient(c)}
+			}
+		}()
+	}
+
+	var failures ProcessErrors
+	finished := make(map[string]bool)
+	// skip marks everything depending on t as failed, recursively
+	var skip func(t *TemplateConfig)
+	skip = func(t *TemplateConfig) {
+		for _, d := range dependents[t.ConfigName] {
+			if finished[d.ConfigName] {
+				continue
+			}
+			finished[d.ConfigName] = true
+			failures = append(failures, ProcessFailure{d, fmt.Errorf("skipped because dependency %s failed", t.ConfigName)})
+			skip(d)
+		}
+	}
+
+	running := 0
+	stopped := false
+	for len(ready) > 0 || running > 0 {
+		var next *TemplateConfig
+		var send chan *TemplateConfig
+		if len(ready) > 0 && !stopped {
+			next = ready[0]
+			send = jobs
+		} else if running == 0 {
+			break
+		}
+
+		select {
+		case send <- next:
+			ready = ready[1:]
+			running++
+		case res := <-results:
+			running--
+			finished[res.template.ConfigName] = true
+			if res.err != nil {
+				log.Errorf("Failed to process %s: %s", res.template.Name, res.err)
+				failures = append(failures, ProcessFailure{res.template, res.err})
+				skip(res.template)
+				if !continueOnError {
+					stopped = true
+				}
+				continue
+			}
+			log.Infof("Successfully wrote: %s", res.template.Dest)
+			for _, d := range dependents[res.template.ConfigName] {
+				pending[d.ConfigName]--
+				if pending[d.ConfigName] == 0 && !finished[d.ConfigName] {
+					ready = append(ready, d)
+				}
+			}
+		}
+	}
+	close(jobs)
+
+	// Anything never started because processing stopped early
+	for _, t := range tcs {
+		if finished[t.ConfigName] {
+			continue
+		}
+		reason := fmt.Errorf("not processed because an earlier template failed")
+		if !stopped {
+			reason = fmt.Errorf("not processed because its dependencies were never written")
+		}
+		failures = append(failures, ProcessFailure{t, reason})
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+	sort.Sort(failuresByName(failures))
+	return failures
+}
+
+type failuresByName ProcessErrors
+
+func (f failuresByName) Len() int      { return len(f) }
+func (f failuresByName) Swap(i, j int) { f[i], f[j] = f[j], f[i] }
+func (f failuresByName) Less(i, j int) bool {
+	return f[i].Template.ConfigName < f[j].Template.ConfigName
+}
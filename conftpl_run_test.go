@@ -0,0 +1,105 @@
+package confclient
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestProcessAllDependencyOrder(t *testing.T) {
+	var mu sync.Mutex
+	var order []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/string/")
+		mu.Lock()
+		order = append(order, key)
+		mu.Unlock()
+		if key == "missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"type":"string","data":{"value":"` + key + `","source":"global:` + key + `"}}`))
+	}))
+	defer ts.Close()
+
+	c, base := setupConfigDirs(t)
+	defer os.RemoveAll(base)
+	c = InitiateClient(ts.URL)
+	c.ConfigDir = filepath.Join(base, "conf.d")
+	c.TemplateDir = filepath.Join(base, "templates")
+	out := filepath.Join(base, "out")
+	os.Mkdir(out, 0755)
+
+	writeTestFile(t, filepath.Join(c.TemplateDir, "a.tmpl"), `{{key "a"}}`)
+	writeTestFile(t, filepath.Join(c.TemplateDir, "b.tmpl"), `{{key "b"}}`)
+	writeTestFile(t, filepath.Join(c.TemplateDir, "fail.tmpl"), `{{key "missing"}}`)
+	writeTestFile(t, filepath.Join(c.ConfigDir, "a.toml"), "[template]\nsrc = \"a.tmpl\"\ndest = \""+out+"/a\"\ndepends_on = [\"b\"]\n")
+	writeTestFile(t, filepath.Join(c.ConfigDir, "b.toml"), "[template]\nsrc = \"b.tmpl\"\ndest = \""+out+"/b\"\n")
+	writeTestFile(t, filepath.Join(c.ConfigDir, "c.toml"), "[template]\nsrc = \"fail.tmpl\"\ndest = \""+out+"/c\"\n")
+	writeTestFile(t, filepath.Join(c.ConfigDir, "d.toml"), "[template]\nsrc = \"a.tmpl\"\ndest = \""+out+"/d\"\ndepends_on = [\"c\"]\n")
+
+	tcs, err := c.LoadConfigFiles()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	err = tcs.ProcessAll(c, 4, true)
+	perrs, ok := err.(ProcessErrors)
+	if !ok {
+		t.Fatalf("Expected ProcessErrors, got %T: %v", err, err)
+	}
+	if len(perrs) != 2 || perrs[0].Template.ConfigName != "c" || perrs[1].Template.ConfigName != "d" {
+		t.Errorf("Expected c to fail and d to be skipped, got:\n%s", err)
+	}
+	if !strings.Contains(err.Error(), "dependency c failed") {
+		t.Errorf("Expected skip reason in summary:\n%s", err)
+	}
+
+	pos := make(map[string]int)
+	for i, name := range order {
+		pos[name] = i
+	}
+	if len(order) != 3 || pos["a"] < pos["b"] {
+		t.Errorf("Expected b to be looked up before a and d never to run: %v", order)
+	}
+	for _, name := range []string{"a", "b"} {
+		if b, err := ioutil.ReadFile(filepath.Join(out, name)); err != nil || string(b) != name {
+			t.Errorf("Expected %s to be written: %q %v", name, b, err)
+		}
+	}
+}
+
+func TestProcessAllChecksDependencies(t *testing.T) {
+	tcs := TemplateConfigs{
+		{ConfigName: "a", Name: "a.tmpl", DependsOn: []string{"b"}},
+		{ConfigName: "b", Name: "b.tmpl", DependsOn: []string{"a"}},
+		{ConfigName: "c", Name: "c.tmpl", DependsOn: []string{"missing"}},
+	}
+	err := tcs.ProcessAll(InitiateClient("http://localhost:0"), 2, true)
+	if _, ok := err.(ConfigErrors); !ok || !strings.Contains(err.Error(), "unknown config 'missing'") {
+		t.Errorf("Expected unknown dependency error, got %T: %v", err, err)
+	}
+
+	err = tcs[:2].ProcessAll(InitiateClient("http://localhost:0"), 2, true)
+	if err == nil || !strings.Contains(err.Error(), "dependency cycle") {
+		t.Errorf("Expected dependency cycle error, got: %v", err)
+	}
+}
+
+func TestLoadConfigFilesDependencyCycle(t *testing.T) {
+	c, base := setupConfigDirs(t)
+	defer os.RemoveAll(base)
+
+	writeTestFile(t, filepath.Join(c.ConfigDir, "a.toml"), "[template]\nsrc = \"a.tmpl\"\ndest = \"/tmp/a\"\ndepends_on = [\"b\"]\n")
+	writeTestFile(t, filepath.Join(c.ConfigDir, "b.toml"), "[template]\nsrc = \"b.tmpl\"\ndest = \"/tmp/b\"\ndepends_on = [\"a\"]\n")
+
+	_, err := c.LoadConfigFiles()
+	if err == nil || !strings.Contains(err.Error(), "dependency cycle: a -> b -> a") {
+		t.Errorf("Expected dependency cycle error, got: %v", err)
+	}
+}
@@ -1,6 +1,8 @@
 package confclient
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
@@ -68,3 +70,29 @@ func TestGetList(t *testing.T) {
 		t.Logf("Got response: %d => %s", idx, entry)
 	}
 }
+
+func TestWithScope(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"type":"string","data":{"value":"` + r.Header.Get("x-cfg-instance") + `","source":"test"}}`))
+	}))
+	defer ts.Close()
+
+	c := InitiateClient(ts.URL)
+	scoped := c.WithScope(map[string]string{"Instance": "redis-6380"})
+
+	val, err := scoped.GetStringValue("port")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if val != "redis-6380" {
+		t.Errorf("Expected scoped lookup to send x-cfg-instance, got '%s'", val)
+	}
+
+	val, err = c.GetStringValue("port")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if val != "" {
+		t.Errorf("Scope leaked into parent client: '%s'", val)
+	}
+}
@@ -0,0 +1,112 @@
+package confclient
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"sort"
+	"strings"
+)
+
+// KeyValue is a key found by ListKeyValues together with its value. Value is
+// a string, []string or map[string]string depending on Type.
+type KeyValue struct {
+	Key   string
+	Name  string
+	Type  string
+	Value interface{}
+}
+
+// ListKeyValues returns every key matching pattern (e.g. "sites:ams1:vhosts:*")
+// with its value, ordered by key name. Name is the last ':' separated part
+// of the key. Values are looked up through the client's scope like single
+// keys, so the recorded sources are the ones the server resolved.
+func (c *Client) ListKeyValues(pattern string) ([]KeyValue, error) {
+	keys, err := c.AdminListKeys(pattern)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+
+	kvs := make([]KeyValue, 0, len(keys))
+	for _, k := range keys {
+		ktype, err := c.AdminGetKeyType(k)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot get type of key %s: %s", k, err)
+		}
+		val, err := c.getScopedValue(k, ktype)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot get key %s: %s", k, err)
+		}
+		log.WithFields(log.Fields{
+			"key":  k,
+			"type": ktype,
+		}).Debug("Got tree key")
+		kvs = append(kvs, KeyValue{
+			Key:   k,
+			Name:  k[strings.LastIndex(k, ":")+1:],
+			Type:  ktype,
+			Value: val,
+		})
+	}
+	return kvs, nil
+}
+
+// getScopedValue resolves key of type ktype through the client's scope, like
+// the str, list and hash template functions, and records its sources
+func (c *Client) getScopedValue(key string, ktype string) (interface{}, error) {
+	switch ktype {
+	case "string":
+		return c.GetStringValue(key)
+	case "list":
+		return c.GetListValue(key)
+	case "hash":
+		kps, err := c.GetHashValue(key)
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[string]string)
+		for _, kp := range kps {
+			m[kp.Key] = kp.Value
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("unsupported key type '%s'", ktype)
+}
+
+// GetTree returns all keys below prefix as nested maps, split on ':'. Leaves
+// hold the key's value, so for the keys sites:ams1:vhosts:www (a hash) and
+// sites:ams1:vhosts:api (a hash), GetTree("sites:ams1:vhosts") returns
+// {"api": {...}, "www": {...}}.
+func (c *Client) GetTree(prefix string) (map[string]interface{}, error) {
+	prefix = strings.TrimSuffix(prefix, ":")
+	kvs, err := c.ListKeyValues(prefix + ":*")
+	if err != nil {
+		return nil, err
+	}
+
+	tree := make(map[string]interface{})
+	for _, kv := range kvs {
+		parts := strings.Split(strings.TrimPrefix(kv.Key, prefix+":"), ":")
+		node := tree
+		for i, part := range parts {
+			if i == len(parts)-1 {
+				if _, exists := node[part]; exists {
+					return nil, fmt.Errorf("tree %s: key %s has both a value and child keys", prefix, kv.Key)
+				}
+				node[part] = kv.Value
+				break
+			}
+			child, exists := node[part]
+			if !exists {
+				child = make(map[string]interface{})
+				node[part] = child
+			}
+			next, ok := child.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("tree %s: key %s has both a value and child keys", prefix, strings.Join(append([]string{prefix}, parts[:i+1]...), ":"))
+			}
+			node = next
+		}
+	}
+	return tree, nil
+}
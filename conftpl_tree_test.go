@@ -0,0 +1,149 @@
+package confclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func newTreeServer(t *testing.T, keys map[string]KeyResponse) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/admin/keys/"):
+			prefix := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/admin/keys/"), "*")
+			var resp SimpleListResponse
+			for k := range keys {
+				if strings.HasPrefix(k, prefix) {
+					resp.Data = append(resp.Data, k)
+				}
+			}
+			json.NewEncoder(w).Encode(resp)
+		case r.Method == "PATCH" && strings.HasPrefix(r.URL.Path, "/admin/key/append/"):
+			name := strings.TrimPrefix(r.URL.Path, "/admin/key/append/")
+			var req DataRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			l, _ := keys[name].Data.([]interface{})
+			keys[name] = KeyResponse{"list", append(l, req.Data)}
+		case strings.HasPrefix(r.URL.Path, "/admin/util/type/"):
+			kr, ok := keys[strings.TrimPrefix(r.URL.Path, "/admin/util/type/")]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode(KeyResponse{"string", kr.Type})
+		case strings.HasPrefix(r.URL.Path, "/string/"), strings.HasPrefix(r.URL.Path, "/list/"), strings.HasPrefix(r.URL.Path, "/hash/"):
+			// Scoped lookups, resolved from the "default" scope
+			parts := strings.SplitN(r.URL.Path, "/", 3)
+			kr, ok := keys[parts[2]]
+			if !ok || kr.Type != parts[1] {
+				http.NotFound(w, r)
+				return
+			}
+			switch d := kr.Data.(type) {
+			case string:
+				json.NewEncoder(w).Encode(StringResponse{kr.Type, ValueSource{d, "default"}})
+			case []interface{}:
+				resp := ListResponse{Type: kr.Type}
+				for _, v := range d {
+					resp.Data = append(resp.Data, ValueSource{fmt.Sprint(v), "default"})
+				}
+				json.NewEncoder(w).Encode(resp)
+			case map[string]interface{}:
+				resp := HashResponse{kr.Type, make(map[string]ValueSource)}
+				for f, v := range d {
+					resp.Data[f] = ValueSource{fmt.Sprint(v), "default"}
+				}
+				json.NewEncoder(w).Encode(resp)
+			}
+		case strings.HasPrefix(r.URL.Path, "/admin/key/"):
+			name := strings.TrimPrefix(r.URL.Path, "/admin/key/")
+			if r.Method == "POST" {
+				var kr KeyResponse
+				if err := json.NewDecoder(r.Body).Decode(&kr); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				if i := strings.LastIndex(name, "/"); kr.Type == "" && i > 0 {
+					// Setting a hash field, {"data": "value"}
+					m, _ := keys[name[:i]].Data.(map[string]interface{})
+					if m == nil {
+						m = make(map[string]interface{})
+					}
+					m[name[i+1:]] = kr.Data
+					keys[name[:i]] = KeyResponse{"hash", m}
+					return
+				}
+				keys[name] = kr
+				return
+			}
+			if r.Method == "DELETE" {
+				delete(keys, name)
+				return
+			}
+			kr, ok := keys[name]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode(kr)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestListKeyValuesAndTree(t *testing.T) {
+	ts := newTreeServer(t, map[string]KeyResponse{
+		"sites:ams1:vhosts:www":          {"hash", map[string]interface{}{"port": "80"}},
+		"sites:ams1:vhosts:api":          {"hash", map[string]interface{}{"port": "8080"}},
+		"sites:ams1:upstreams:api:hosts": {"list", []interface{}{"a", "b"}},
+		"sites:ams1:name":                {"string", "Amsterdam"},
+	})
+	defer ts.Close()
+	recorder := NewLookupRecorder()
+	c := InitiateClient(ts.URL).WithRecorder(recorder)
+
+	kvs, err := c.ListKeyValues("sites:ams1:vhosts:*")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if len(kvs) != 2 || kvs[0].Name != "api" || kvs[0].Type != "hash" || kvs[1].Key != "sites:ams1:vhosts:www" {
+		t.Errorf("Unexpected keys: %+v", kvs)
+	}
+
+	tmpl := template.Must(template.New("test").Funcs(FuncMap(c)).Parse(
+		`{{with tree "sites:ams1"}}{{.name}}:{{range $k, $v := .vhosts}} {{$k}}={{$v.port}}{{end}} {{index .upstreams.api.hosts 1}}{{end}}`))
+	var buffer bytes.Buffer
+	if err := tmpl.Execute(&buffer, nil); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if buffer.String() != "Amsterdam: api=8080 www=80 b" {
+		t.Errorf("Unexpected output: %q", buffer.String())
+	}
+	for _, l := range recorder.Lookups() {
+		if l.Source != "default" {
+			t.Errorf("Expected %s to be recorded from the scope, got source %q", l.Key, l.Source)
+		}
+	}
+	if n := len(recorder.Lookups()); n != 4 {
+		t.Errorf("Expected 4 recorded lookups, got %d", n)
+	}
+}
+
+func TestTreeConflict(t *testing.T) {
+	ts := newTreeServer(t, map[string]KeyResponse{
+		"app:db":      {"hash", map[string]interface{}{"host": "db1"}},
+		"app:db:port": {"string", "5432"},
+	})
+	defer ts.Close()
+
+	_, err := InitiateClient(ts.URL).GetTree("app")
+	if err == nil || !strings.Contains(err.Error(), "app:db has both a value and child keys") {
+		t.Errorf("Expected conflict error, got: %v", err)
+	}
+}
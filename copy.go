@@ -0,0 +1,136 @@
+package confclient
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"sort"
+	"strings"
+)
+
+// KeyExistsError is returned when a copy or move with noClobber set would
+// overwrite existing keys. Nothing is written.
+type KeyExistsError struct {
+	Keys []string
+}
+
+func (e *KeyExistsError) Error() string {
+	return fmt.Sprintf("Keys already exist, not overwritten: %s", strings.Join(e.Keys, ", "))
+}
+
+// AdminCopyKey copies the string, hash or list key src to dst. If noClobber
+// is set and dst exists a *KeyExistsError is returned.
+func (c *Client) AdminCopyKey(src string, dst string, noClobber bool) error {
+	if src == dst {
+		return fmt.Errorf("Cannot copy key %s onto itself", src)
+	}
+	_, err := c.adminCopyKeys(map[string]string{src: dst}, noClobber)
+	return err
+}
+
+// AdminMoveKey renames src to dst: it is copied like AdminCopyKey, then src
+// is deleted IfUnchanged since it was copied. If src was changed in the
+// meantime both keys are kept and a *ConflictError is returned.
+func (c *Client) AdminMoveKey(src string, dst string, noClobber bool) error {
+	if src == dst {
+		return fmt.Errorf("Cannot move key %s onto itself", src)
+	}
+	versions, err := c.adminCopyKeys(map[string]string{src: dst}, noClobber)
+	if err != nil {
+		return err
+	}
+	err = c.IfUnchanged(versions[src]).AdminDeleteKey(src)
+	if IsConflict(err) {
+		log.WithFields(log.Fields{"src": src, "dst": dst}).Warn("Key changed while it was moved, kept both keys")
+		return &ConflictError{Keys: []string{src}}
+	}
+	if err != nil {
+		return fmt.Errorf("Cannot delete key %s: %s", src, err)
+	}
+	return nil
+}
+
+// AdminCopyTree copies the key srcPrefix, if it exists, and every key below
+// it to the same names below dstPrefix, e.g. nodes:web01:ip to
+// nodes:web02:ip. It returns the number of keys copied. With noClobber, no key
+// is copied if any destination exists.
+func (c *Client) AdminCopyTree(srcPrefix string, dstPrefix string, noClobber bool) (int, error) {
+	srcPrefix = strings.TrimSuffix(srcPrefix, ":")
+	dstPrefix = strings.TrimSuffix(dstPrefix, ":")
+	if srcPrefix == dstPrefix {
+		return 0, fmt.Errorf("Cannot copy %s onto itself", srcPrefix)
+	}
+
+	keys, err := c.AdminListKeys(srcPrefix + ":*")
+	if err != nil {
+		return 0, err
+	}
+	names := make(map[string]string)
+	for _, k := range keys {
+		names[k] = dstPrefix + strings.TrimPrefix(k, srcPrefix)
+	}
+	if _, err := c.AdminGetKey(srcPrefix); err == nil {
+		names[srcPrefix] = dstPrefix
+	} else if !IsNotFound(err) {
+		return 0, fmt.Errorf("Cannot get key %s: %s", srcPrefix, err)
+	}
+	if len(names) == 0 {
+		return 0, fmt.Errorf("No keys found below %s", srcPrefix)
+	}
+	_, err = c.adminCopyKeys(names, noClobber)
+	return len(names), err
+}
+
+// adminCopyKeys copies each source key in names to its destination. With
+// noClobber destinations are written IfUnchanged(VersionAbsent), so a key
+// created in the meantime is not overwritten. When copying several keys every
+// destination is also checked before anything is written. It returns the
+// version of each source key as it was copied.
+func (c *Client) adminCopyKeys(names map[string]string, noClobber bool) (map[string]string, error) {
+	srcs := make([]string, 0, len(names))
+	for src := range names {
+		srcs = append(srcs, src)
+	}
+	sort.Strings(srcs)
+
+	if noClobber && len(srcs) > 1 {
+		exists := make([]string, 0)
+		for _, src := range srcs {
+			_, err := c.AdminGetKey(names[src])
+			if err == nil {
+				exists = append(exists, names[src])
+			} else if !IsNotFound(err) {
+				return nil, fmt.Errorf("Cannot get key %s: %s", names[src], err)
+			}
+		}
+		if len(exists) > 0 {
+			return nil, &KeyExistsError{Keys: exists}
+		}
+	}
+
+	writer := c
+	if noClobber {
+		writer = c.IfUnchanged(VersionAbsent)
+	}
+	versions := make(map[string]string)
+	for _, src := range srcs {
+		kr, version, err := c.AdminGetKeyVersion(src)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot get key %s: %s", src, err)
+		}
+		versions[src] = version
+		jsonblob, err := json.Marshal(kr)
+		if err != nil {
+			return nil, err
+		}
+		err = writer.AdminSetKeyFromJSON(names[src], jsonblob)
+		if noClobber && IsConflict(err) {
+			return nil, &KeyExistsError{Keys: []string{names[src]}}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Cannot set key %s: %s", names[src], err)
+		}
+		log.WithFields(log.Fields{"src": src, "dst": names[src], "type": kr.Type}).Debug("Copied key")
+	}
+	return versions, nil
+}
@@ -0,0 +1,81 @@
+package confclient
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestCopyAndMoveKeys(t *testing.T) {
+	keys := map[string]KeyResponse{
+		"nodes:web01":       {"string", "web01.example.com"},
+		"nodes:web01:ips":   {"list", []interface{}{"10.0.0.1", "10.0.0.2"}},
+		"nodes:web01:nginx": {"hash", map[string]interface{}{"workers": "4"}},
+		"nodes:web02:nginx": {"hash", map[string]interface{}{"workers": "8"}},
+		"nodes:db01:typo":   {"string", "x"},
+	}
+	ts := newTreeServer(t, keys)
+	defer ts.Close()
+	c := InitiateClient(ts.URL)
+
+	_, err := c.AdminCopyTree("nodes:web01", "nodes:web02", true)
+	if e, ok := err.(*KeyExistsError); !ok || !reflect.DeepEqual(e.Keys, []string{"nodes:web02:nginx"}) {
+		t.Fatalf("Expected nodes:web02:nginx to exist, got %v", err)
+	}
+	if _, ok := keys["nodes:web02:ips"]; ok {
+		t.Errorf("Expected nothing to be copied with no-clobber")
+	}
+
+	n, err := c.AdminCopyTree("nodes:web01", "nodes:web02", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("Expected 3 keys to be copied, got %d", n)
+	}
+	for _, name := range []string{"", ":ips", ":nginx"} {
+		if !reflect.DeepEqual(keys["nodes:web02"+name], keys["nodes:web01"+name]) {
+			t.Errorf("Expected nodes:web02%s to be %v, got %v", name, keys["nodes:web01"+name], keys["nodes:web02"+name])
+		}
+	}
+
+	if err := c.AdminMoveKey("nodes:db01:typo", "nodes:db01:type", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := keys["nodes:db01:typo"]; ok || keys["nodes:db01:type"].Data != "x" {
+		t.Errorf("Expected nodes:db01:typo to be renamed, got %v", keys)
+	}
+
+	err = c.AdminCopyKey("nodes:db01:type", "nodes:web01", true)
+	if e, ok := err.(*KeyExistsError); !ok || e.Keys[0] != "nodes:web01" {
+		t.Errorf("Expected copying onto an existing key with no-clobber to fail, got %v", err)
+	}
+	if keys["nodes:web01"].Data != "web01.example.com" {
+		t.Errorf("Expected nodes:web01 not to be overwritten, got %v", keys["nodes:web01"])
+	}
+}
+
+func TestMoveKeyKeepsSourceChangedDuringMove(t *testing.T) {
+	keys := map[string]KeyResponse{
+		"app:old": {"string", "v1"},
+	}
+	ts := newTreeServer(t, keys)
+	defer ts.Close()
+	handler := ts.Config.Handler
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r)
+		// Someone writes the source right after it was copied
+		if r.Method == "POST" && r.URL.Path == "/admin/key/app:new" {
+			keys["app:old"] = KeyResponse{"string", "v2"}
+		}
+	})
+	c := InitiateClient(ts.URL)
+
+	err := c.AdminMoveKey("app:old", "app:new", false)
+	if e, ok := err.(*ConflictError); !ok || e.Keys[0] != "app:old" {
+		t.Fatalf("Expected a conflict on app:old, got %v", err)
+	}
+	if keys["app:old"].Data != "v2" || keys["app:new"].Data != "v1" {
+		t.Errorf("Expected both keys to be kept, got %v", keys)
+	}
+}
@@ -0,0 +1,220 @@
+package confclient
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// lookupFuncs are the template functions whose first argument is a key name
+// or key pattern
+var lookupFuncs = map[string]bool{
+	"key":     true,
+	"keyd":    true,
+	"list":    true,
+	"listj":   true,
+	"listd":   true,
+	"hash":    true,
+	"hexists": true,
+	"sexists": true,
+	"lexists": true,
+	"keys":    true,
+	"tree":    true,
+	"secret":  true,
+}
+
+// DynamicKey is a lookup whose key is not a string literal and so cannot be
+// resolved without rendering the template. Includes of a file which is not a
+// string literal are listed too, as the keys used in it are unknown.
+type DynamicKey struct {
+	Template   string `json:"template"`
+	Location   string `json:"location"`
+	Function   string `json:"function"`
+	Expression string `json:"expression"`
+}
+
+// DepsReport maps every key used by the templates in a directory to the
+// templates using it
+type DepsReport struct {
+	Keys    map[string][]string `json:"keys"`
+	Dynamic []DynamicKey        `json:"dynamic"`
+	Errors  []string            `json:"errors,omitempty"`
+}
+
+// KeyDeps parses every file below templateDir without executing it and
+// collects the string literal arguments of the key lookup functions.
+// Templates are named by their path relative to templateDir. Keys used in
+// the partials of partialsDir, if set, and in included files are credited to
+// every template invoking or including them. Files which fail to parse are
+// listed in Errors.
+func KeyDeps(templateDir string, partialsDir string) (*DepsReport, error) {
+	report := &DepsReport{
+		Keys:    make(map[string][]string),
+		Dynamic: make([]DynamicKey, 0),
+	}
+	d := &depsParser{templateDir: templateDir, funcs: parseFuncMap()}
+	d.funcs["include"] = notExecutable
+	if partialsDir != "" {
+		partials, err := filepath.Glob(filepath.Join(partialsDir, "*.tmpl"))
+		if err != nil {
+			return nil, err
+		}
+		d.partials = partials
+	}
+
+	err := filepath.Walk(templateDir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(templateDir, path)
+		if err != nil {
+			return err
+		}
+		if err := d.addFile(report, rel, rel, make(map[string]bool)); err != nil {
+			report.Errors = append(report.Errors, err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for key, tpls := range report.Keys {
+		report.Keys[key] = uniqueSorted(tpls)
+	}
+	report.Errors = uniqueSorted(report.Errors)
+	return report, nil
+}
+
+// depsParser parses template files like parseFile does, without executing
+// them
+type depsParser struct {
+	templateDir string
+	partials    []string
+	funcs       template.FuncMap
+}
+
+// parse parses the file rel of the template directory along with the
+// partials
+func (d *depsParser) parse(rel string) (*template.Template, error) {
+	content, err := ioutil.ReadFile(filepath.Join(d.templateDir, rel))
+	if err != nil {
+		return nil, err
+	}
+	tmpl := template.New(rel).Funcs(d.funcs)
+	if len(d.partials) > 0 {
+		if _, err := tmpl.ParseFiles(d.partials...); err != nil {
+			return nil, fmt.Errorf("Error parsing partials: %s", err)
+		}
+	}
+	// Parsed last so definitions in the file take precedence over partials
+	if _, err := tmpl.New(rel).Parse(string(content)); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// addFile credits the keys used in the file rel to tplName: those in the
+// templates it defines, the templates they invoke and the files they
+// include. seen holds the files already added for tplName.
+func (d *depsParser) addFile(report *DepsReport, tplName string, rel string, seen map[string]bool) error {
+	if seen[rel] {
+		return nil
+	}
+	seen[rel] = true
+	tmpl, err := d.parse(rel)
+	if err != nil {
+		return fmt.Errorf("%s: %s", rel, err)
+	}
+
+	// Every template defined in the file, then whatever they invoke
+	var queue []*template.Template
+	visited := make(map[string]bool)
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil && t.Tree.ParseName == rel {
+			queue = append(queue, t)
+			visited[t.Name()] = true
+		}
+	}
+	var includes []string
+	for len(queue) > 0 {
+		t := queue[0]
+		queue = queue[1:]
+		tree := t.Tree
+		walkNodes(tree.Root, func(n parse.Node) {
+			if tn, ok := n.(*parse.TemplateNode); ok {
+				if ref := tmpl.Lookup(tn.Name); ref != nil && ref.Tree != nil && !visited[tn.Name] {
+					visited[tn.Name] = true
+					queue = append(queue, ref)
+				}
+				return
+			}
+			if inc, ok := report.addCommand(tplName, tree, n); ok {
+				includes = append(includes, inc)
+			}
+		})
+	}
+
+	for _, inc := range includes {
+		path := filepath.Join(d.templateDir, inc)
+		if r, err := filepath.Rel(d.templateDir, path); err != nil || r == ".." || strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("%s: cannot include %s: outside template directory", rel, inc)
+		}
+		if err := d.addFile(report, tplName, filepath.Clean(inc), seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addCommand records the key looked up by n. For an include of a literal
+// file name it returns the name instead.
+func (r *DepsReport) addCommand(tplName string, tree *parse.Tree, n parse.Node) (string, bool) {
+	cmd, ok := n.(*parse.CommandNode)
+	if !ok || len(cmd.Args) == 0 {
+		return "", false
+	}
+	ident, ok := cmd.Args[0].(*parse.IdentifierNode)
+	if !ok || !(lookupFuncs[ident.Ident] || ident.Ident == "include") {
+		return "", false
+	}
+	if len(cmd.Args) > 1 {
+		if s, ok := cmd.Args[1].(*parse.StringNode); ok {
+			if ident.Ident == "include" {
+				return s.Text, true
+			}
+			r.Keys[s.Text] = append(r.Keys[s.Text], tplName)
+			return "", false
+		}
+	}
+	// Key is a variable, field, function result or piped in
+	location, _ := tree.ErrorContext(cmd)
+	r.Dynamic = append(r.Dynamic, DynamicKey{
+		Template:   tplName,
+		Location:   location,
+		Function:   ident.Ident,
+		Expression: cmd.String(),
+	})
+	return "", false
+}
+
+func uniqueSorted(l []string) []string {
+	seen := make(map[string]bool)
+	out := make([]string, 0, len(l))
+	for _, s := range l {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,80 @@
+package confclient
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeyDeps(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conftpl")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	os.Mkdir(filepath.Join(dir, "sub"), 0755)
+
+	writeTestFile(t, filepath.Join(dir, "app.tmpl"), `{{key "global:db_host"}} {{range list "backends"}}{{.}}{{end}} {{key .name "x"}}`)
+	writeTestFile(t, filepath.Join(dir, "sub", "web.tmpl"), `{{define "x"}}{{with keyd "global:db_host" "y"}}{{.Value}}{{end}}{{end}}{{"name" | hash}}`)
+	writeTestFile(t, filepath.Join(dir, "broken.tmpl"), `{{key "a"`)
+
+	report, err := KeyDeps(dir, "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	users := report.Keys["global:db_host"]
+	if len(users) != 2 || users[0] != "app.tmpl" || users[1] != "sub/web.tmpl" {
+		t.Errorf("Unexpected users of global:db_host: %v", users)
+	}
+	if len(report.Keys["backends"]) != 1 {
+		t.Errorf("Expected backends to be found: %v", report.Keys)
+	}
+	if len(report.Dynamic) != 2 {
+		t.Fatalf("Expected 2 dynamic keys, got %+v", report.Dynamic)
+	}
+	for _, d := range report.Dynamic {
+		if d.Function == "key" && d.Expression != `key .name "x"` {
+			t.Errorf("Unexpected dynamic expression: %+v", d)
+		}
+	}
+	if len(report.Errors) != 1 {
+		t.Errorf("Expected broken.tmpl to be reported: %v", report.Errors)
+	}
+}
+
+func TestKeyDepsPartialsAndIncludes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conftpl")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	templates := filepath.Join(dir, "templates")
+	partials := filepath.Join(dir, "partials")
+	os.Mkdir(templates, 0755)
+	os.Mkdir(partials, 0755)
+
+	writeTestFile(t, filepath.Join(partials, "db.tmpl"), `{{define "db"}}{{key "db_host"}}{{end}}{{define "unused"}}{{key "other"}}{{end}}`)
+	writeTestFile(t, filepath.Join(templates, "app.tmpl"), `{{template "db"}} {{include "common.inc"}} {{(tree "sites").name}}`)
+	writeTestFile(t, filepath.Join(templates, "common.inc"), `{{key "log_level"}} {{include .file}}`)
+
+	report, err := KeyDeps(templates, partials)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	for _, key := range []string{"db_host", "log_level", "sites"} {
+		users := report.Keys[key]
+		if len(users) == 0 || users[0] != "app.tmpl" {
+			t.Errorf("Expected app.tmpl to use %s, got %v", key, users)
+		}
+	}
+	if users := report.Keys["log_level"]; len(users) != 2 || users[1] != "common.inc" {
+		t.Errorf("Expected app.tmpl and common.inc to use log_level, got %v", users)
+	}
+	if _, ok := report.Keys["other"]; ok {
+		t.Errorf("Expected the unused partial not to be credited: %v", report.Keys)
+	}
+	if len(report.Dynamic) != 2 || report.Dynamic[0].Function != "include" {
+		t.Errorf("Expected the dynamic include of both templates, got %+v", report.Dynamic)
+	}
+}
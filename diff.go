@@ -0,0 +1,273 @@
+package confclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Diff changes
+const (
+	DiffAdded   = "added"
+	DiffRemoved = "removed"
+	DiffChanged = "changed"
+)
+
+// KeyDiff is a key which differs between two sets of keys. Src and Dst hold
+// the whole value of added and removed keys, of strings and of keys whose
+// type changed. Hashes list their changed fields and lists their changed
+// elements instead.
+type KeyDiff struct {
+	Key      string        `json:"key"`
+	Change   string        `json:"change"`
+	SrcType  string        `json:"src_type,omitempty"`
+	DstType  string        `json:"dst_type,omitempty"`
+	Src      interface{}   `json:"src,omitempty"`
+	Dst      interface{}   `json:"dst,omitempty"`
+	Fields   []FieldDiff   `json:"fields,omitempty"`
+	Elements []ElementDiff `json:"elements,omitempty"`
+}
+
+// FieldDiff is a hash field which was added, removed or changed
+type FieldDiff struct {
+	Field  string      `json:"field"`
+	Change string      `json:"change"`
+	Src    interface{} `json:"src,omitempty"`
+	Dst    interface{} `json:"dst,omitempty"`
+}
+
+// ElementDiff is a list element which is only in the source list (removed,
+// Index into the source) or only in the destination list (added, Index into
+// the destination)
+type ElementDiff struct {
+	Change string      `json:"change"`
+	Index  int         `json:"index"`
+	Value  interface{} `json:"value"`
+}
+
+// IsServerURL reports whether a diff or sync side is a server rather than a
+// dump
+func IsServerURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// ReadKeys gets the keys matching opts.Filter from a server URL, or reads
+// every key from a dump directory or archive
+func ReadKeys(source string, opts DumpOptions) (map[string]KeyResponse, error) {
+	if IsServerURL(source) {
+		return InitiateClient(source).adminGetKeys(opts)
+	}
+	return ReadDump(source)
+}
+
+// DiffKeys compares two sets of keys, returning the differences ordered by
+// key name
+func DiffKeys(src map[string]KeyResponse, dst map[string]KeyResponse) []KeyDiff {
+	names := make(map[string]bool)
+	for k := range src {
+		names[k] = true
+	}
+	for k := range dst {
+		names[k] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for k := range names {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	diffs := make([]KeyDiff, 0)
+	for _, k := range sorted {
+		s, inSrc := src[k]
+		d, inDst := dst[k]
+		switch {
+		case !inDst:
+			diffs = append(diffs, KeyDiff{Key: k, Change: DiffRemoved, SrcType: s.Type, Src: s.Data})
+		case !inSrc:
+			diffs = append(diffs, KeyDiff{Key: k, Change: DiffAdded, DstType: d.Type, Dst: d.Data})
+		case !reflect.DeepEqual(s, d):
+			diffs = append(diffs, diffKey(k, s, d))
+		}
+	}
+	return diffs
+}
+
+func diffKey(k string, s KeyResponse, d KeyResponse) KeyDiff {
+	diff := KeyDiff{Key: k, Change: DiffChanged, SrcType: s.Type, DstType: d.Type}
+	if s.Type == d.Type {
+		switch sd := s.Data.(type) {
+		case map[string]interface{}:
+			if dd, ok := d.Data.(map[string]interface{}); ok {
+				diff.Fields = diffFields(sd, dd)
+				return diff
+			}
+		case []interface{}:
+			if dd, ok := d.Data.([]interface{}); ok {
+				diff.Elements = diffElements(sd, dd)
+				return diff
+			}
+		}
+	}
+	diff.Src = s.Data
+	diff.Dst = d.Data
+	return diff
+}
+
+func diffFields(src map[string]interface{}, dst map[string]interface{}) []FieldDiff {
+	fields := make([]string, 0, len(src)+len(dst))
+	for f := range src {
+		fields = append(fields, f)
+	}
+	for f := range dst {
+		if _, ok := src[f]; !ok {
+			fields = append(fields, f)
+		}
+	}
+	sort.Strings(fields)
+
+	diffs := make([]FieldDiff, 0)
+	for _, f := range fields {
+		s, inSrc := src[f]
+		d, inDst := dst[f]
+		switch {
+		case !inDst:
+			diffs = append(diffs, FieldDiff{Field: f, Change: DiffRemoved, Src: s})
+		case !inSrc:
+			diffs = append(diffs, FieldDiff{Field: f, Change: DiffAdded, Dst: d})
+		case !reflect.DeepEqual(s, d):
+			diffs = append(diffs, FieldDiff{Field: f, Change: DiffChanged, Src: s, Dst: d})
+		}
+	}
+	return diffs
+}
+
+// diffElements diffs two lists by their longest common subsequence
+func diffElements(src []interface{}, dst []interface{}) []ElementDiff {
+	// lcs[i][j] is the LCS length of src[i:] and dst[j:]
+	lcs := make([][]int, len(src)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(dst)+1)
+	}
+	for i := len(src) - 1; i >= 0; i-- {
+		for j := len(dst) - 1; j >= 0; j-- {
+			if reflect.DeepEqual(src[i], dst[j]) {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	diffs := make([]ElementDiff, 0)
+	i, j := 0, 0
+	for i < len(src) || j < len(dst) {
+		switch {
+		case i < len(src) && j < len(dst) && reflect.DeepEqual(src[i], dst[j]):
+			i++
+			j++
+		case j == len(dst) || (i < len(src) && lcs[i+1][j] >= lcs[i][j+1]):
+			diffs = append(diffs, ElementDiff{Change: DiffRemoved, Index: i, Value: src[i]})
+			i++
+		default:
+			diffs = append(diffs, ElementDiff{Change: DiffAdded, Index: j, Value: dst[j]})
+			j++
+		}
+	}
+	return diffs
+}
+
+// RedactDiffs returns a copy of diffs with secret values replaced, see
+// IsSecretValue
+func RedactDiffs(diffs []KeyDiff) []KeyDiff {
+	redact := func(key string, field string, v interface{}) interface{} {
+		if v == nil {
+			return nil
+		}
+		if field != "" {
+			if s, ok := v.(string); ok && IsSecretValue(key, field, s) {
+				return Redacted
+			}
+			return v
+		}
+		kr, _ := RedactKeyResponse(key, KeyResponse{Data: v})
+		return kr.Data
+	}
+
+	out := make([]KeyDiff, len(diffs))
+	for i, d := range diffs {
+		d.Src = redact(d.Key, "", d.Src)
+		d.Dst = redact(d.Key, "", d.Dst)
+		fields := make([]FieldDiff, len(d.Fields))
+		for j, f := range d.Fields {
+			f.Src = redact(d.Key, f.Field, f.Src)
+			f.Dst = redact(d.Key, f.Field, f.Dst)
+			fields[j] = f
+		}
+		elements := make([]ElementDiff, len(d.Elements))
+		for j, e := range d.Elements {
+			e.Value = redact(d.Key, "", e.Value)
+			elements[j] = e
+		}
+		if d.Fields != nil {
+			d.Fields = fields
+		}
+		if d.Elements != nil {
+			d.Elements = elements
+		}
+		out[i] = d
+	}
+	return out
+}
+
+// FormatDiffs renders diffs as text: "+" for added, "-" for removed and "~"
+// for changed keys, followed by their changed fields or elements
+func FormatDiffs(diffs []KeyDiff) string {
+	var buf bytes.Buffer
+	for _, d := range diffs {
+		switch {
+		case d.Change == DiffAdded:
+			fmt.Fprintf(&buf, "+ %s (%s) %s\n", d.Key, d.DstType, formatDiffValue(d.Dst))
+		case d.Change == DiffRemoved:
+			fmt.Fprintf(&buf, "- %s (%s) %s\n", d.Key, d.SrcType, formatDiffValue(d.Src))
+		case d.SrcType != d.DstType:
+			fmt.Fprintf(&buf, "~ %s (%s -> %s)\n", d.Key, d.SrcType, d.DstType)
+			fmt.Fprintf(&buf, "    - %s\n    + %s\n", formatDiffValue(d.Src), formatDiffValue(d.Dst))
+		default:
+			fmt.Fprintf(&buf, "~ %s (%s)\n", d.Key, d.SrcType)
+			for _, f := range d.Fields {
+				switch f.Change {
+				case DiffAdded:
+					fmt.Fprintf(&buf, "    + %s: %s\n", f.Field, formatDiffValue(f.Dst))
+				case DiffRemoved:
+					fmt.Fprintf(&buf, "    - %s: %s\n", f.Field, formatDiffValue(f.Src))
+				default:
+					fmt.Fprintf(&buf, "    ~ %s: %s -> %s\n", f.Field, formatDiffValue(f.Src), formatDiffValue(f.Dst))
+				}
+			}
+			for _, e := range d.Elements {
+				sign := "+"
+				if e.Change == DiffRemoved {
+					sign = "-"
+				}
+				fmt.Fprintf(&buf, "    %s [%d] %s\n", sign, e.Index, formatDiffValue(e.Value))
+			}
+			if d.Fields == nil && d.Elements == nil {
+				fmt.Fprintf(&buf, "    - %s\n    + %s\n", formatDiffValue(d.Src), formatDiffValue(d.Dst))
+			}
+		}
+	}
+	return buf.String()
+}
+
+func formatDiffValue(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return fmt.Sprintf("%q", s)
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
@@ -0,0 +1,71 @@
+package confclient
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffKeys(t *testing.T) {
+	src := map[string]KeyResponse{
+		"app:name":     {"string", "app"},
+		"app:old":      {"string", "x"},
+		"app:hosts":    {"list", []interface{}{"a", "b", "c"}},
+		"app:db":       {"hash", map[string]interface{}{"host": "db1", "port": "5432", "password": "hunter22"}},
+		"app:same":     {"string", "same"},
+		"app:typechng": {"string", "a"},
+	}
+	dst := map[string]KeyResponse{
+		"app:name":     {"string", "app2"},
+		"app:new":      {"string", "y"},
+		"app:hosts":    {"list", []interface{}{"a", "c", "d"}},
+		"app:db":       {"hash", map[string]interface{}{"host": "db2", "user": "app", "password": "hunter23"}},
+		"app:same":     {"string", "same"},
+		"app:typechng": {"list", []interface{}{"a"}},
+	}
+
+	diffs := DiffKeys(src, dst)
+	expected := []KeyDiff{
+		{Key: "app:db", Change: DiffChanged, SrcType: "hash", DstType: "hash", Fields: []FieldDiff{
+			{Field: "host", Change: DiffChanged, Src: "db1", Dst: "db2"},
+			{Field: "password", Change: DiffChanged, Src: "hunter22", Dst: "hunter23"},
+			{Field: "port", Change: DiffRemoved, Src: "5432"},
+			{Field: "user", Change: DiffAdded, Dst: "app"},
+		}},
+		{Key: "app:hosts", Change: DiffChanged, SrcType: "list", DstType: "list", Elements: []ElementDiff{
+			{Change: DiffRemoved, Index: 1, Value: "b"},
+			{Change: DiffAdded, Index: 2, Value: "d"},
+		}},
+		{Key: "app:name", Change: DiffChanged, SrcType: "string", DstType: "string", Src: "app", Dst: "app2"},
+		{Key: "app:new", Change: DiffAdded, DstType: "string", Dst: "y"},
+		{Key: "app:old", Change: DiffRemoved, SrcType: "string", Src: "x"},
+		{Key: "app:typechng", Change: DiffChanged, SrcType: "string", DstType: "list", Src: "a", Dst: []interface{}{"a"}},
+	}
+	if !reflect.DeepEqual(diffs, expected) {
+		t.Fatalf("Expected\n%+v\ngot\n%+v", expected, diffs)
+	}
+
+	text := FormatDiffs(RedactDiffs(diffs))
+	expectedText := `~ app:db (hash)
+    ~ host: "db1" -> "db2"
+    ~ password: "[REDACTED]" -> "[REDACTED]"
+    - port: "5432"
+    + user: "app"
+~ app:hosts (list)
+    - [1] "b"
+    + [2] "d"
+~ app:name (string)
+    - "app"
+    + "app2"
++ app:new (string) "y"
+- app:old (string) "x"
+~ app:typechng (string -> list)
+    - "a"
+    + ["a"]
+`
+	if text != expectedText {
+		t.Errorf("Expected\n%s\ngot\n%s", expectedText, text)
+	}
+	if diffs[0].Fields[1].Src != "hunter22" {
+		t.Errorf("RedactDiffs modified its argument")
+	}
+}
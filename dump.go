@@ -0,0 +1,196 @@
+package confclient
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+type DumpOptions struct {
+	// Filter is the key pattern to dump, "*" if empty
+	Filter string
+	// Workers is the number of keys fetched in parallel, at least 1
+	Workers int
+	// Progress, if set, is called after each key is dumped
+	Progress func(done int, total int)
+}
+
+// AdminDumpKeys writes each key matching opts.Filter to its own JSON file in
+// output, named by KeyFileName. Files are written to a temporary name and
+// renamed, so dumping again into the same directory replaces them whole.
+// Files of keys which were not dumped this time, e.g. because they were
+// deleted, are left alone and a warning lists them.
+func (c *Client) AdminDumpKeys(output string, opts DumpOptions) error {
+	err := os.MkdirAll(output, 0755)
+	if err != nil {
+		return err
+	}
+
+	var mu sync.Mutex
+	written := make(map[string]bool)
+	err = c.adminFetchKeys(opts, func(k string, kr KeyResponse) error {
+		jsonblob, err := json.MarshalIndent(kr, "", "  ")
+		if err != nil {
+			return err
+		}
+		localfile := filepath.Join(output, KeyFileName(k))
+		log.Debugf("  Writing %d bytes to %s", len(jsonblob), localfile)
+		if err := writeFileAtomic(localfile, jsonblob, 0644); err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		written[KeyFileName(k)] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	warnStaleFiles(output, written)
+	return nil
+}
+
+// warnStaleFiles warns about files in a dump directory which were not
+// written by the dump, as loading the directory would load them too
+func warnStaleFiles(output string, written map[string]bool) {
+	files, err := ioutil.ReadDir(output)
+	if err != nil {
+		log.Warnf("Cannot check %s for stale files: %s", output, err)
+		return
+	}
+	stale := make([]string, 0)
+	for _, fi := range files {
+		if !strings.HasPrefix(fi.Name(), ".") && fi.Mode().IsRegular() && !written[fi.Name()] {
+			stale = append(stale, fi.Name())
+		}
+	}
+	if len(stale) > 0 {
+		log.Warnf("%d files in %s were not written by this dump and may be stale: %s", len(stale), output, strings.Join(stale, ", "))
+	}
+}
+
+// writeFileAtomic writes data to a hidden temporary file beside path and
+// renames it to path
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	return writeStreamAtomic(path, mode, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// writeStreamAtomic is writeFileAtomic for content written by fn, so it does
+// not have to be held in memory
+func writeStreamAtomic(path string, mode os.FileMode, fn func(w io.Writer) error) error {
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	fh, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return fmt.Errorf("Cannot write %s: %s", tmp, err)
+	}
+	bw := bufio.NewWriter(fh)
+	err = fn(bw)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if cerr := fh.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("Cannot write %s: %s", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("Cannot rename %s to %s: %s", tmp, path, err)
+	}
+	return nil
+}
+
+// adminGetKeys gets every key matching opts.Filter
+func (c *Client) adminGetKeys(opts DumpOptions) (map[string]KeyResponse, error) {
+	var mu sync.Mutex
+	keys := make(map[string]KeyResponse)
+	err := c.adminFetchKeys(opts, func(k string, kr KeyResponse) error {
+		mu.Lock()
+		defer mu.Unlock()
+		keys[k] = kr
+		return nil
+	})
+	return keys, err
+}
+
+// adminFetchKeys gets every key matching opts.Filter with opts.Workers
+// requests in parallel and calls fn for each of them, from the worker
+// goroutines. The first error stops all workers.
+func (c *Client) adminFetchKeys(opts DumpOptions, fn func(k string, kr KeyResponse) error) error {
+	filter := opts.Filter
+	if filter == "" {
+		filter = "*"
+	}
+	workers := opts.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	names, err := c.AdminListKeys(filter)
+	if err != nil {
+		return err
+	}
+
+	jobs := make(chan string)
+	stop := make(chan struct{})
+	var mu sync.Mutex
+	var firstErr error
+	done := 0
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			close(stop)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := range jobs {
+				log.Debugf("Dumping key %s", k)
+				kr, err := c.AdminGetKey(k)
+				if err != nil {
+					fail(fmt.Errorf("Cannot get key %s: %s", k, err))
+					continue
+				}
+				if err := fn(k, kr); err != nil {
+					fail(fmt.Errorf("Cannot dump key %s: %s", k, err))
+					continue
+				}
+				mu.Lock()
+				done++
+				if opts.Progress != nil {
+					opts.Progress(done, len(names))
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, k := range names {
+		select {
+		case jobs <- k:
+		case <-stop:
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return firstErr
+}
@@ -0,0 +1,79 @@
+package confclient
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAdminDumpKeys(t *testing.T) {
+	keys := map[string]KeyResponse{
+		"app:name": {"string", "a much longer name"},
+		"app:port": {"string", "8080"},
+		"app:db":   {"hash", map[string]interface{}{"host": "db1"}},
+		"other:x":  {"string", "x"},
+	}
+	ts := newTreeServer(t, keys)
+	defer ts.Close()
+	c := InitiateClient(ts.URL)
+
+	dir, _ := ioutil.TempDir("", "confadm")
+	defer os.RemoveAll(dir)
+
+	last, total := 0, 0
+	opts := DumpOptions{
+		Filter:   "app:*",
+		Workers:  3,
+		Progress: func(done int, n int) { last, total = done, n },
+	}
+	if err := c.AdminDumpKeys(dir, opts); err != nil {
+		t.Fatal(err)
+	}
+	if last != 3 || total != 3 {
+		t.Errorf("Expected progress to reach 3/3, got %d/%d", last, total)
+	}
+
+	// Dump again with a shorter value, which used to leave the old tail behind
+	keys["app:name"] = KeyResponse{"string", "short"}
+	if err := c.AdminDumpKeys(dir, opts); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadDumpDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != 3 || read["app:name"].Data != "short" {
+		t.Errorf("Unexpected dump: %v", read)
+	}
+	if _, ok := read["other:x"]; ok {
+		t.Errorf("Key outside the filter was dumped")
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	for _, fi := range files {
+		if fi.Mode().Perm() != 0644 {
+			t.Errorf("Expected %s to have mode 0644, got %o", fi.Name(), fi.Mode().Perm())
+		}
+	}
+	if len(files) != 3 {
+		t.Errorf("Expected 3 files and no leftovers, got %d", len(files))
+	}
+
+	if err := c.AdminDumpKeys(filepath.Join(dir, "missing"), DumpOptions{Filter: "nomatch:*"}); err != nil {
+		t.Errorf("Expected an empty dump to succeed: %s", err)
+	}
+
+	// Files which are not keys are skipped when reading the dump back
+	writeTestFile(t, filepath.Join(dir, "README"), "Dump of app:*\n")
+	writeTestFile(t, filepath.Join(dir, "notes.json"), `{"owner":"ops"}`)
+	os.Mkdir(filepath.Join(dir, "sub"), 0755)
+	writeTestFile(t, filepath.Join(dir, "sub", "app:x"), `{"type":"string","data":"x"}`)
+	read, err = ReadDumpDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != 3 {
+		t.Errorf("Expected only the 3 key files to be read, got %v", read)
+	}
+}
@@ -0,0 +1,103 @@
+package confclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v2"
+)
+
+// EditFormats are the formats MarshalKey and UnmarshalKey support
+var EditFormats = []string{"yaml", "json"}
+
+// MarshalKey renders a key in format for editing, as a document with its
+// type and data
+func MarshalKey(kr KeyResponse, format string) ([]byte, error) {
+	switch format {
+	case "yaml":
+		return yaml.Marshal(kr)
+	case "json":
+		b, err := json.MarshalIndent(kr, "", "  ")
+		return append(b, '\n'), err
+	}
+	return nil, fmt.Errorf("Unknown edit format '%s'", format)
+}
+
+// UnmarshalKey parses a key rendered by MarshalKey and checks its data
+// matches its type: a string, a list of strings or a hash of strings.
+// JSON numbers and booleans are turned into strings; in YAML they must be
+// quoted, see yamlStrings.
+func UnmarshalKey(b []byte, format string) (KeyResponse, error) {
+	var kr KeyResponse
+	switch format {
+	case "yaml":
+		if err := yaml.Unmarshal(b, &kr); err != nil {
+			return kr, err
+		}
+		if err := yamlStrings("data", kr.Data); err != nil {
+			return kr, err
+		}
+	case "json":
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		if err := dec.Decode(&kr); err != nil {
+			return kr, err
+		}
+	default:
+		return kr, fmt.Errorf("Unknown edit format '%s'", format)
+	}
+	return checkKey(kr)
+}
+
+// checkKey checks the data of kr matches its type and turns numbers and
+// booleans into strings
+func checkKey(kr KeyResponse) (KeyResponse, error) {
+	switch kr.Type {
+	case "string":
+		s, ok := stateScalar(kr.Data)
+		if !ok {
+			return kr, fmt.Errorf("data of a string key must be a string")
+		}
+		kr.Data = s
+	case "list":
+		items, ok := kr.Data.([]interface{})
+		if !ok {
+			return kr, fmt.Errorf("data of a list key must be a list")
+		}
+		l := make([]interface{}, len(items))
+		for i, item := range items {
+			s, ok := stateScalar(item)
+			if !ok {
+				return kr, fmt.Errorf("list item %d must be a string", i)
+			}
+			l[i] = s
+		}
+		kr.Data = l
+	case "hash":
+		fields := stateMap(kr.Data)
+		if fields == nil {
+			return kr, fmt.Errorf("data of a hash key must be a map")
+		}
+		m := make(map[string]interface{})
+		for field, v := range fields {
+			s, ok := stateScalar(v)
+			if !ok {
+				return kr, fmt.Errorf("hash field %s must be a string", field)
+			}
+			m[field] = s
+		}
+		kr.Data = m
+	default:
+		return kr, fmt.Errorf("Unknown key type '%s', expected string, list or hash", kr.Type)
+	}
+	return kr, nil
+}
+
+// AdminSetKeyIfUnchanged writes kr to keyName, unless the key no longer
+// holds original because it was changed since it was read. Then nothing is
+// written and a *ConflictError is returned. It is a thin wrapper around
+// c.IfUnchanged(KeyVersion(original)) for callers which kept the value they
+// read rather than its version.
+func (c *Client) AdminSetKeyIfUnchanged(keyName string, original KeyResponse, kr KeyResponse) error {
+	return c.IfUnchanged(KeyVersion(original)).adminSetKey(keyName, kr)
+}
@@ -0,0 +1,79 @@
+package confclient
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMarshalKeyRoundTrip(t *testing.T) {
+	for _, kr := range []KeyResponse{
+		{"string", "0755"},
+		{"list", []interface{}{"a", "true"}},
+		{"hash", map[string]interface{}{"host": "db1", "port": "5432"}},
+	} {
+		for _, format := range EditFormats {
+			b, err := MarshalKey(kr, format)
+			if err != nil {
+				t.Fatalf("%s: %s", format, err)
+			}
+			read, err := UnmarshalKey(b, format)
+			if err != nil {
+				t.Fatalf("%s: %s", format, err)
+			}
+			if !reflect.DeepEqual(read, kr) {
+				t.Errorf("%s: expected %v, got %v", format, kr, read)
+			}
+		}
+	}
+
+	read, err := UnmarshalKey([]byte("type: hash\ndata:\n  port: '5432'\n"), "yaml")
+	if err != nil || !reflect.DeepEqual(read.Data, map[string]interface{}{"port": "5432"}) {
+		t.Errorf("Unexpected key %v: %v", read, err)
+	}
+	read, err = UnmarshalKey([]byte(`{"type":"hash","data":{"port":5432}}`), "json")
+	if err != nil || !reflect.DeepEqual(read.Data, map[string]interface{}{"port": "5432"}) {
+		t.Errorf("Unexpected key %v: %v", read, err)
+	}
+	for _, unquoted := range []string{
+		"type: string\ndata: 0755\n",
+		"type: list\ndata: [yes]\n",
+		"type: hash\ndata:\n  version: 1.10\n",
+	} {
+		if _, err := UnmarshalKey([]byte(unquoted), "yaml"); err == nil || !strings.Contains(err.Error(), "quote it") {
+			t.Errorf("Expected %q to be refused until quoted, got %v", unquoted, err)
+		}
+	}
+	for _, invalid := range []string{
+		"type: hash\ndata: [a]\n",
+		"type: list\ndata:\n  - [a]\n",
+		"type: set\ndata: a\n",
+	} {
+		if _, err := UnmarshalKey([]byte(invalid), "yaml"); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestSetKeyIfUnchanged(t *testing.T) {
+	keys := map[string]KeyResponse{
+		"app:db": {"hash", map[string]interface{}{"host": "db1"}},
+	}
+	ts := newTreeServer(t, keys)
+	defer ts.Close()
+	c := InitiateClient(ts.URL)
+
+	original := keys["app:db"]
+	edited := KeyResponse{"hash", map[string]interface{}{"host": "db2"}}
+	keys["app:db"] = KeyResponse{"hash", map[string]interface{}{"host": "db3"}}
+	if _, ok := c.AdminSetKeyIfUnchanged("app:db", original, edited).(*ConflictError); !ok {
+		t.Errorf("Expected a conflict")
+	}
+
+	if err := c.AdminSetKeyIfUnchanged("app:db", keys["app:db"], edited); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(keys["app:db"], edited) {
+		t.Errorf("Expected %v, got %v", edited, keys["app:db"])
+	}
+}
@@ -0,0 +1,395 @@
+package confclient
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ExportFormats are the formats ExportKeys writes and ImportKeys reads
+var ExportFormats = []string{"yaml", "toml", "env", "properties"}
+
+// ExportKeys writes keys in format. yaml and toml map each key name to a
+// string, list or map. env and properties write one line per key, with lists
+// and hashes as JSON values. Strings which look like JSON are written as JSON
+// strings so they are not read back as lists or hashes. env variable names are made by EnvName, which
+// is lossy, so keys whose name would be read back differently are refused.
+func ExportKeys(w io.Writer, keys map[string]KeyResponse, format string) error {
+	switch format {
+	case "yaml":
+		b, err := yaml.Marshal(exportValues(keys))
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(b)
+		return err
+	case "toml":
+		return toml.NewEncoder(w).Encode(exportValues(keys))
+	case "env", "properties":
+		for _, k := range sortedKeyNames(keys) {
+			value, err := exportString(keys[k])
+			if err != nil {
+				return fmt.Errorf("Cannot export key %s: %s", k, err)
+			}
+			if format == "env" {
+				if envKeyName(EnvName(k)) != k {
+					return fmt.Errorf("Cannot export key %s as %s, it would be imported as %s", k, EnvName(k), envKeyName(EnvName(k)))
+				}
+				_, err = fmt.Fprintf(w, "%s=%s\n", EnvName(k), shellQuote(value))
+			} else {
+				_, err = fmt.Fprintf(w, "%s=%s\n", propertiesEscape(k, true), propertiesEscape(value, false))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("Unknown export format '%s'", format)
+}
+
+// ImportKeys reads keys written in format, inferring their type: maps become
+// hashes, arrays become lists and scalars become strings. YAML scalars must
+// be quoted unless they are plain strings, see yamlStrings. In env and
+// properties files, values which are a JSON array or object become lists and
+// hashes, JSON strings are decoded, and env variable names become key names in lower case with "__" as
+// ':'.
+func ImportKeys(r io.Reader, format string) (map[string]KeyResponse, error) {
+	var values map[string]interface{}
+	switch format {
+	case "yaml":
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		var m map[interface{}]interface{}
+		if err := yaml.Unmarshal(b, &m); err != nil {
+			return nil, err
+		}
+		if err := yamlStrings("", m); err != nil {
+			return nil, err
+		}
+		values = stateMap(m)
+	case "toml":
+		if _, err := toml.DecodeReader(r, &values); err != nil {
+			return nil, err
+		}
+	case "env", "properties":
+		var err error
+		if values, err = readKeyValueLines(r, format); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("Unknown import format '%s'", format)
+	}
+
+	// Only lines of text hold lists and hashes as JSON
+	sniffJSON := format == "env" || format == "properties"
+	keys := make(map[string]KeyResponse)
+	for k, v := range values {
+		kr, err := inferKey(v, sniffJSON)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot import key %s: %s", k, err)
+		}
+		keys[k] = kr
+	}
+	return keys, nil
+}
+
+// AdminExportKeys writes the keys matching pattern to w in format, see
+// ExportKeys. Unless reveal is set, secret and encrypted values are written
+// as Redacted, see RedactKeyResponse.
+func (c *Client) AdminExportKeys(w io.Writer, pattern string, format string, reveal bool) error {
+	keys, err := c.adminGetKeys(DumpOptions{Filter: pattern})
+	if err != nil {
+		return err
+	}
+	if !reveal {
+		for k, kr := range keys {
+			keys[k], _ = RedactKeyResponse(k, kr)
+		}
+	}
+	return ExportKeys(w, keys, format)
+}
+
+// AdminImportKeys reads keys in format from r, see ImportKeys, and sets them
+// like AdminLoadKeyMap
+func (c *Client) AdminImportKeys(r io.Reader, format string, opts LoadOptions) (*LoadResult, error) {
+	keys, err := ImportKeys(r, format)
+	if err != nil {
+		return nil, err
+	}
+	return c.AdminLoadKeyMap(keys, opts)
+}
+
+// exportValues maps key names to plain strings, lists and maps
+func exportValues(keys map[string]KeyResponse) map[string]interface{} {
+	values := make(map[string]interface{})
+	for k, kr := range keys {
+		values[k] = kr.Data
+	}
+	return values
+}
+
+// exportString is the value of a string key, or a list or hash as JSON.
+// Strings which would be read back as JSON are written as a JSON string.
+func exportString(kr KeyResponse) (string, error) {
+	if s, ok := kr.Data.(string); ok {
+		if _, isJSON := decodeJSONValue(s); !isJSON {
+			return s, nil
+		}
+	}
+	b, err := json.Marshal(kr.Data)
+	return string(b), err
+}
+
+// decodeJSONValue decodes s if it holds a JSON array, object or string
+func decodeJSONValue(s string) (interface{}, bool) {
+	trimmed := strings.TrimSpace(s)
+	if !strings.HasPrefix(trimmed, "[") && !strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, `"`) {
+		return nil, false
+	}
+	var decoded interface{}
+	if err := json.Unmarshal([]byte(trimmed), &decoded); err != nil {
+		return nil, false
+	}
+	return decoded, true
+}
+
+// inferKey turns a value read from an import file into a key. With
+// sniffJSON, strings holding a JSON array, object or string are decoded
+// first, as written by exportString.
+func inferKey(v interface{}, sniffJSON bool) (KeyResponse, error) {
+	if s, ok := v.(string); ok && sniffJSON {
+		if decoded, isJSON := decodeJSONValue(s); isJSON {
+			v = decoded
+		}
+	}
+
+	switch val := v.(type) {
+	case []interface{}:
+		l := make([]interface{}, len(val))
+		for i, item := range val {
+			s, ok := stateScalar(item)
+			if !ok {
+				return KeyResponse{}, fmt.Errorf("lists can only hold strings, numbers and booleans")
+			}
+			l[i] = s
+		}
+		return KeyResponse{Type: "list", Data: l}, nil
+	case map[string]interface{}, map[interface{}]interface{}:
+		m := make(map[string]interface{})
+		for field, fv := range stateMap(val) {
+			s, ok := stateScalar(fv)
+			if !ok {
+				return KeyResponse{}, fmt.Errorf("hash field %s can only hold a string, number or boolean", field)
+			}
+			m[field] = s
+		}
+		return KeyResponse{Type: "hash", Data: m}, nil
+	}
+	s, ok := stateScalar(v)
+	if !ok {
+		return KeyResponse{}, fmt.Errorf("no value")
+	}
+	return KeyResponse{Type: "string", Data: s}, nil
+}
+
+var envNameInvalid = regexp.MustCompile(`[^A-Z0-9_]`)
+
+// EnvName is the environment variable name a key is exported as, e.g.
+// SITES__AMS1__DB_HOST for sites:ams1:db-host. The mapping is lossy: upper
+// case letters and characters other than letters, digits, '_' and ':' are
+// not kept, so sites:ams1:db-host is imported again as sites:ams1:db_host.
+func EnvName(key string) string {
+	name := strings.Replace(strings.ToUpper(key), ":", "__", -1)
+	return envNameInvalid.ReplaceAllString(name, "_")
+}
+
+// envKeyName is the key name an env variable is imported as
+func envKeyName(name string) string {
+	return strings.Replace(strings.ToLower(name), "__", ":", -1)
+}
+
+// shellQuote single quotes s for a shell
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// shellUnquote reverses shellQuote, and also accepts double quoted and bare
+// values
+func shellUnquote(s string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				end = len(s) - i - 1
+			}
+			buf.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+		case c == '"':
+			for i++; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(`"\$`+"`", s[i+1]) >= 0 {
+					i++
+				}
+				buf.WriteByte(s[i])
+			}
+		case c == '\\' && i+1 < len(s):
+			i++
+			buf.WriteByte(s[i])
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String()
+}
+
+// propertiesEscape escapes a .properties key or value
+func propertiesEscape(s string, key bool) string {
+	var buf bytes.Buffer
+	for i, r := range s {
+		switch {
+		case r == '\\':
+			buf.WriteString(`\\`)
+		case r == '\n':
+			buf.WriteString(`\n`)
+		case r == '\r':
+			buf.WriteString(`\r`)
+		case r == '\t':
+			buf.WriteString(`\t`)
+		case key && strings.ContainsRune(":= #!", r):
+			buf.WriteRune('\\')
+			buf.WriteRune(r)
+		case !key && i == 0 && r == ' ':
+			buf.WriteString(`\ `)
+		default:
+			buf.WriteRune(r)
+		}
+	}
+	return buf.String()
+}
+
+// propertiesUnescape reverses propertiesEscape, including \uXXXX escapes
+func propertiesUnescape(s string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			buf.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			buf.WriteByte('\n')
+		case 'r':
+			buf.WriteByte('\r')
+		case 't':
+			buf.WriteByte('\t')
+		case 'f':
+			buf.WriteByte('\f')
+		case 'u':
+			if i+5 <= len(s) {
+				if r, err := strconv.ParseUint(s[i+1:i+5], 16, 32); err == nil {
+					buf.WriteRune(rune(r))
+					i += 4
+					continue
+				}
+			}
+			buf.WriteByte('u')
+		default:
+			buf.WriteByte(s[i])
+		}
+	}
+	return buf.String()
+}
+
+// readKeyValueLines reads an env or properties file into key names and
+// string values
+func readKeyValueLines(r io.Reader, format string) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	pending := ""
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if pending == "" || format == "properties" {
+			line = strings.TrimLeft(line, " \t\f")
+		}
+		line = pending + line
+		pending = ""
+		if line == "" || line[0] == '#' || (format == "properties" && line[0] == '!') {
+			continue
+		}
+
+		if format == "env" {
+			// Quoted values may span lines
+			if shellQuoteOpen(line) {
+				pending = line + "\n"
+				continue
+			}
+			line = strings.TrimPrefix(line, "export ")
+			i := strings.IndexByte(line, '=')
+			if i <= 0 {
+				return nil, fmt.Errorf("line %d: expected NAME=value", n)
+			}
+			values[envKeyName(strings.TrimSpace(line[:i]))] = shellUnquote(strings.TrimSpace(line[i+1:]))
+			continue
+		}
+
+		// An odd number of trailing backslashes continues the line
+		trailing := len(line) - len(strings.TrimRight(line, `\`))
+		if trailing%2 == 1 {
+			pending = line[:len(line)-1]
+			continue
+		}
+		// The key ends at the first unescaped '=', ':' or whitespace
+		end := len(line)
+		for i := 0; i < len(line); i++ {
+			if line[i] == '\\' {
+				i++
+				continue
+			}
+			if strings.IndexByte("=: \t\f", line[i]) >= 0 {
+				end = i
+				break
+			}
+		}
+		rest := strings.TrimLeft(line[end:], " \t\f")
+		if rest != "" && (rest[0] == '=' || rest[0] == ':') {
+			rest = strings.TrimLeft(rest[1:], " \t\f")
+		}
+		values[propertiesUnescape(line[:end])] = propertiesUnescape(rest)
+	}
+	if pending != "" && scanner.Err() == nil {
+		return nil, fmt.Errorf("unexpected end of file in quoted value or continued line")
+	}
+	return values, scanner.Err()
+}
+
+// shellQuoteOpen reports whether s ends inside a quoted string
+func shellQuoteOpen(s string) bool {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote == 0 && s[i] == '\\':
+			i++
+		case quote == 0 && (s[i] == '\'' || s[i] == '"'):
+			quote = s[i]
+		case quote == '"' && s[i] == '\\':
+			i++
+		case quote != 0 && s[i] == quote:
+			quote = 0
+		}
+	}
+	return quote != 0
+}
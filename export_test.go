@@ -0,0 +1,155 @@
+package confclient
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExportImportRoundTrip(t *testing.T) {
+	keys := map[string]KeyResponse{
+		"sites:ams1:name":  {"string", "it's \"Amsterdam\"\n  line two"},
+		"sites:ams1:hosts": {"list", []interface{}{"a", "b c"}},
+		"sites:ams1:db":    {"hash", map[string]interface{}{"host": "db1", "port": "5432"}},
+		"sites:ams1:empty": {"string", " leading space"},
+		"sites:ams1:json":  {"string", `["a"]`},
+		"sites:ams1:obj":   {"string", ` {"x":1}`},
+		"sites:ams1:quote": {"string", `"quoted"`},
+	}
+
+	for _, format := range ExportFormats {
+		var buf bytes.Buffer
+		if err := ExportKeys(&buf, keys, format); err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+		read, err := ImportKeys(&buf, format)
+		if err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+		if !reflect.DeepEqual(read, keys) {
+			t.Errorf("%s: expected %v, got %v", format, keys, read)
+		}
+	}
+}
+
+func TestImportInfersTypes(t *testing.T) {
+	for format, input := range map[string]string{
+		"yaml":       "app:port: '8080'\napp:hosts: [a, b]\napp:db: {host: db1, tls: 'true'}\n",
+		"toml":       "\"app:port\" = 8080\n\"app:hosts\" = [\"a\", \"b\"]\n[\"app:db\"]\nhost = \"db1\"\ntls = true\n",
+		"env":        "# comment\nexport APP__PORT=8080\nAPP__HOSTS='[\"a\",\"b\"]'\nAPP__DB=\"{\\\"host\\\":\\\"db1\\\",\\\"tls\\\":true}\"\n",
+		"properties": "! comment\napp\\:port : 8080\napp\\:hosts=[\"a\",\\\n  \"b\"]\napp\\:db {\"host\":\"db1\",\"tls\":true}\n",
+	} {
+		keys, err := ImportKeys(strings.NewReader(input), format)
+		if err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+		expected := map[string]KeyResponse{
+			"app:port":  {"string", "8080"},
+			"app:hosts": {"list", []interface{}{"a", "b"}},
+			"app:db":    {"hash", map[string]interface{}{"host": "db1", "tls": "true"}},
+		}
+		if !reflect.DeepEqual(keys, expected) {
+			t.Errorf("%s: expected %v, got %v", format, expected, keys)
+		}
+	}
+}
+
+func TestImportKeepsJSONStrings(t *testing.T) {
+	keys, err := ImportKeys(strings.NewReader("app:cfg: '{\"a\":\"b\"}'\napp:ids: '[1]'\n"), "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]KeyResponse{
+		"app:cfg": {"string", `{"a":"b"}`},
+		"app:ids": {"string", "[1]"},
+	}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Expected %v, got %v", expected, keys)
+	}
+}
+
+func TestImportRefusesUnquotedYAML(t *testing.T) {
+	for _, input := range []string{"app:mode: 0755\n", "app:flags: {enabled: yes}\n", "app:vers: [1.10]\n"} {
+		if _, err := ImportKeys(strings.NewReader(input), "yaml"); err == nil || !strings.Contains(err.Error(), "quote it") {
+			t.Errorf("%q: expected an unquoted scalar to be refused, got %v", input, err)
+		}
+	}
+	keys, err := ImportKeys(strings.NewReader("app:mode: '0755'\napp:flags: {enabled: 'yes'}\napp:vers: ['1.10']\n"), "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]KeyResponse{
+		"app:mode":  {"string", "0755"},
+		"app:flags": {"hash", map[string]interface{}{"enabled": "yes"}},
+		"app:vers":  {"list", []interface{}{"1.10"}},
+	}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Expected %v, got %v", expected, keys)
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	if name := EnvName("sites:ams1:db-host"); name != "SITES__AMS1__DB_HOST" {
+		t.Errorf("Unexpected env name %s", name)
+	}
+	keys := map[string]KeyResponse{"sites:ams1:db-host": {"string", "db1"}}
+	var buf bytes.Buffer
+	if err := ExportKeys(&buf, keys, "env"); err == nil {
+		t.Errorf("Expected a key which does not survive the env name mapping to be refused")
+	}
+}
+
+func TestAdminExportKeysRedacts(t *testing.T) {
+	ts := newTreeServer(t, map[string]KeyResponse{
+		"app:db:password": {"string", "hunter22"},
+		"app:db":          {"hash", map[string]interface{}{"host": "db1", "api_key": "abcdef"}},
+	})
+	defer ts.Close()
+	c := InitiateClient(ts.URL)
+
+	var buf bytes.Buffer
+	if err := c.AdminExportKeys(&buf, "app:*", "properties", false); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "hunter22") || strings.Contains(buf.String(), "abcdef") || !strings.Contains(buf.String(), "db1") {
+		t.Errorf("Expected secrets to be redacted, got %q", buf.String())
+	}
+	buf.Reset()
+	if err := c.AdminExportKeys(&buf, "app:*", "properties", true); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "hunter22") {
+		t.Errorf("Expected secrets with reveal, got %q", buf.String())
+	}
+}
+
+func TestImportRefusesRedactedExport(t *testing.T) {
+	keys := map[string]KeyResponse{
+		"app:name":        {"string", "web"},
+		"app:db:password": {"string", "hunter22"},
+	}
+	ts := newTreeServer(t, keys)
+	defer ts.Close()
+	c := InitiateClient(ts.URL)
+
+	var buf bytes.Buffer
+	if err := c.AdminExportKeys(&buf, "app:*", "yaml", false); err != nil {
+		t.Fatal(err)
+	}
+	_, err := c.AdminImportKeys(bytes.NewReader(buf.Bytes()), "yaml", LoadOptions{Policy: LoadOverwrite})
+	if err == nil || !strings.Contains(err.Error(), "app:db:password") {
+		t.Errorf("Expected the redacted export to be refused, got %v", err)
+	}
+	if keys["app:db:password"].Data != "hunter22" {
+		t.Errorf("Expected the secret to be kept, got %v", keys["app:db:password"])
+	}
+
+	buf.Reset()
+	if err := c.AdminExportKeys(&buf, "app:*", "yaml", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.AdminImportKeys(&buf, "yaml", LoadOptions{Policy: LoadOverwrite}); err != nil {
+		t.Errorf("Expected a revealed export to import, got %v", err)
+	}
+}
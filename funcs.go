@@ -0,0 +1,453 @@
+package confclient
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+	"math/big"
+	"net"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// FuncMap returns the template functions available to conftpl templates.
+// The key lookup functions (key, list, hash, keys, tree, ...) are bound to c, so a client
+// returned by WithScope gives templates that look up keys in that scope.
+//
+// Helpers which transform a value take it as their last argument so they can
+// be used in pipelines, e.g. {{key "name" | upper}} or
+// {{key "port" "" | default "8080"}}.
+func FuncMap(c *Client) template.FuncMap {
+	fm := template.FuncMap{
+		"key":     c.GetStringValue,
+		"keyd":    c.GetStringValueDebug,
+		"list":    c.GetListValue,
+		"listj":   c.GetListValueJoined,
+		"listd":   c.GetListValueDebug,
+		"hash":    c.GetHashValue,
+		"hexists": c.HashExists,
+		"sexists": c.StringExists,
+		"lexists": c.ListExists,
+		"keys":    c.ListKeyValues,
+		"tree":    c.GetTree,
+		"secret":  c.GetSecretValue,
+	}
+	for name, f := range helperFuncs {
+		fm[name] = f
+	}
+	return fm
+}
+
+// parseFuncMap has the names of every FuncMap function, for parsing templates
+// when no client is available. Its functions must not be executed.
+func parseFuncMap() template.FuncMap {
+	fm := template.FuncMap{}
+	for name := range FuncMap(nil) {
+		fm[name] = notExecutable
+	}
+	return fm
+}
+
+func notExecutable(...interface{}) (string, error) {
+	return "", fmt.Errorf("template functions cannot be executed while only parsing")
+}
+
+// helperFuncs are the template functions which do not need a client
+var helperFuncs = template.FuncMap{
+	// Strings
+	"upper":        strings.ToUpper,
+	"lower":        strings.ToLower,
+	"title":        strings.Title,
+	"trim":         strings.TrimSpace,
+	"trimPrefix":   func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix":   func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"contains":     func(substr, s string) bool { return strings.Contains(s, substr) },
+	"hasPrefix":    func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+	"hasSuffix":    func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+	"split":        func(sep, s string) []string { return strings.Split(s, sep) },
+	"join":         join,
+	"replace":      func(old, new, s string) string { return strings.Replace(s, old, new, -1) },
+	"repeat":       func(count int, s string) string { return strings.Repeat(s, count) },
+	"quote":        strconv.Quote,
+	"indent":       indent,
+	"regexMatch":   regexMatch,
+	"regexReplace": regexReplace,
+
+	// Defaults and conditionals
+	"default":  defaultValue,
+	"coalesce": coalesce,
+	"ternary":  ternary,
+	"empty":    empty,
+
+	// Math
+	"atoi": toInt64,
+	"add":  func(a, b interface{}) (int64, error) { return intOp(a, b, func(x, y int64) int64 { return x + y }) },
+	"sub":  func(a, b interface{}) (int64, error) { return intOp(a, b, func(x, y int64) int64 { return x - y }) },
+	"mul":  func(a, b interface{}) (int64, error) { return intOp(a, b, func(x, y int64) int64 { return x * y }) },
+	"div":  div,
+	"mod":  mod,
+	"max":  func(a, b interface{}) (int64, error) { return intOp(a, b, maxInt64) },
+	"min":  func(a, b interface{}) (int64, error) { return intOp(a, b, minInt64) },
+
+	// Encoding
+	"toJSON":       toJSON,
+	"toPrettyJSON": toPrettyJSON,
+	"toYAML":       toYAML,
+	"toTOML":       toTOML,
+	"b64enc":       func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+	"b64dec":       b64dec,
+	"sha256":       func(s string) string { sum := sha256.Sum256([]byte(s)); return hex.EncodeToString(sum[:]) },
+
+	// IP addresses and networks
+	"isIPv4":       func(s string) bool { ip := net.ParseIP(s); return ip != nil && ip.To4() != nil },
+	"isIPv6":       func(s string) bool { ip := net.ParseIP(s); return ip != nil && ip.To4() == nil },
+	"cidrContains": cidrContains,
+	"cidrNetwork":  cidrNetwork,
+	"cidrNetmask":  cidrNetmask,
+	"cidrHost":     cidrHost,
+
+	// Sorting and lists
+	"sortAlpha": sortAlpha,
+	"sortHash":  sortHash,
+	"reverse":   reverse,
+	"uniq":      uniq,
+}
+
+// toStrings converts a []string or any other slice to a []string
+func toStrings(list interface{}) ([]string, error) {
+	switch l := list.(type) {
+	case []string:
+		return l, nil
+	case nil:
+		return []string{}, nil
+	}
+	v := reflect.ValueOf(list)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, fmt.Errorf("expected a list, got %T", list)
+	}
+	out := make([]string, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		out[i] = fmt.Sprint(v.Index(i).Interface())
+	}
+	return out, nil
+}
+
+func join(sep string, list interface{}) (string, error) {
+	l, err := toStrings(list)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(l, sep), nil
+}
+
+func indent(spaces int, s string) string {
+	pad := strings.Repeat(" ", spaces)
+	return pad + strings.Replace(s, "\n", "\n"+pad, -1)
+}
+
+func regexMatch(regex, s string) (bool, error) {
+	return regexp.MatchString(regex, s)
+}
+
+func regexReplace(regex, repl, s string) (string, error) {
+	re, err := regexp.Compile(regex)
+	if err != nil {
+		return "", err
+	}
+	return re.ReplaceAllString(s, repl), nil
+}
+
+// empty reports whether v is nil or the zero value of its type, including
+// empty strings, lists and maps
+func empty(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return rv.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return rv.IsNil()
+	case reflect.Bool:
+		return !rv.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return rv.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return rv.Float() == 0
+	}
+	return false
+}
+
+func defaultValue(def interface{}, v ...interface{}) interface{} {
+	if len(v) == 0 || empty(v[0]) {
+		return def
+	}
+	return v[0]
+}
+
+func coalesce(v ...interface{}) interface{} {
+	for _, val := range v {
+		if !empty(val) {
+			return val
+		}
+	}
+	return nil
+}
+
+func ternary(vtrue, vfalse interface{}, cond bool) interface{} {
+	if cond {
+		return vtrue
+	}
+	return vfalse
+}
+
+func toInt64(v interface{}) (int64, error) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), nil
+	case int8:
+		return int64(n), nil
+	case int16:
+		return int64(n), nil
+	case int32:
+		return int64(n), nil
+	case int64:
+		return n, nil
+	case uint:
+		return int64(n), nil
+	case uint8:
+		return int64(n), nil
+	case uint16:
+		return int64(n), nil
+	case uint32:
+		return int64(n), nil
+	case uint64:
+		return int64(n), nil
+	case float32:
+		return int64(n), nil
+	case float64:
+		return int64(n), nil
+	case string:
+		i, err := strconv.ParseInt(strings.TrimSpace(n), 0, 64)
+		if err != nil {
+			return 0, fmt.Errorf("cannot convert '%s' to a number", n)
+		}
+		return i, nil
+	}
+	return 0, fmt.Errorf("cannot convert %T to a number", v)
+}
+
+func intOp(a, b interface{}, op func(x, y int64) int64) (int64, error) {
+	x, err := toInt64(a)
+	if err != nil {
+		return 0, err
+	}
+	y, err := toInt64(b)
+	if err != nil {
+		return 0, err
+	}
+	return op(x, y), nil
+}
+
+func div(a, b interface{}) (int64, error) {
+	y, err := toInt64(b)
+	if err != nil {
+		return 0, err
+	}
+	if y == 0 {
+		return 0, fmt.Errorf("division by zero")
+	}
+	return intOp(a, y, func(x, y int64) int64 { return x / y })
+}
+
+func mod(a, b interface{}) (int64, error) {
+	y, err := toInt64(b)
+	if err != nil {
+		return 0, err
+	}
+	if y == 0 {
+		return 0, fmt.Errorf("division by zero")
+	}
+	return intOp(a, y, func(x, y int64) int64 { return x % y })
+}
+
+func maxInt64(x, y int64) int64 {
+	if x > y {
+		return x
+	}
+	return y
+}
+
+func minInt64(x, y int64) int64 {
+	if x < y {
+		return x
+	}
+	return y
+}
+
+// plain turns the lookup result types into plain maps and lists so they
+// encode the way they look in a template
+func plain(v interface{}) interface{} {
+	switch d := v.(type) {
+	case []KeyPair:
+		m := make(map[string]string)
+		for _, kp := range d {
+			m[kp.Key] = kp.Value
+		}
+		return m
+	case ValueSource:
+		return d.Value
+	case []ValueSource:
+		l := make([]string, len(d))
+		for i, vs := range d {
+			l[i] = vs.Value
+		}
+		return l
+	}
+	return v
+}
+
+func toJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(plain(v))
+	return string(b), err
+}
+
+func toPrettyJSON(v interface{}) (string, error) {
+	b, err := json.MarshalIndent(plain(v), "", "  ")
+	return string(b), err
+}
+
+func toYAML(v interface{}) (string, error) {
+	b, err := yaml.Marshal(plain(v))
+	return strings.TrimSuffix(string(b), "\n"), err
+}
+
+func toTOML(v interface{}) (string, error) {
+	var buffer bytes.Buffer
+	if err := toml.NewEncoder(&buffer).Encode(plain(v)); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buffer.String(), "\n"), nil
+}
+
+func b64dec(s string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	return string(b), err
+}
+
+func cidrContains(cidr, ip string) (bool, error) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return false, err
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false, fmt.Errorf("invalid IP address '%s'", ip)
+	}
+	return network.Contains(addr), nil
+}
+
+func cidrNetwork(cidr string) (string, error) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return "", err
+	}
+	return network.IP.String(), nil
+}
+
+func cidrNetmask(cidr string) (string, error) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return "", err
+	}
+	return net.IP(network.Mask).String(), nil
+}
+
+// cidrHost returns the n-th address in the network, negative numbers count
+// back from the end of the network
+func cidrHost(n int, cidr string) (string, error) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return "", err
+	}
+	ones, bits := network.Mask.Size()
+	size := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+	idx := big.NewInt(int64(n))
+	if idx.Sign() < 0 {
+		idx.Add(idx, size)
+	}
+	if idx.Sign() < 0 || idx.Cmp(size) >= 0 {
+		return "", fmt.Errorf("host number %d out of range for %s", n, cidr)
+	}
+
+	addr := new(big.Int).SetBytes(network.IP)
+	b := addr.Add(addr, idx).Bytes()
+	ip := make(net.IP, len(network.IP))
+	copy(ip[len(ip)-len(b):], b)
+	return ip.String(), nil
+}
+
+func sortAlpha(list interface{}) ([]string, error) {
+	l, err := toStrings(list)
+	if err != nil {
+		return nil, err
+	}
+	sorted := make([]string, len(l))
+	copy(sorted, l)
+	sort.Strings(sorted)
+	return sorted, nil
+}
+
+type keyPairsByKey []KeyPair
+
+func (k keyPairsByKey) Len() int           { return len(k) }
+func (k keyPairsByKey) Swap(i, j int)      { k[i], k[j] = k[j], k[i] }
+func (k keyPairsByKey) Less(i, j int) bool { return k[i].Key < k[j].Key }
+
+// sortHash orders the result of hash by field name
+func sortHash(kps []KeyPair) []KeyPair {
+	sorted := make([]KeyPair, len(kps))
+	copy(sorted, kps)
+	sort.Sort(keyPairsByKey(sorted))
+	return sorted
+}
+
+func reverse(list interface{}) ([]string, error) {
+	l, err := toStrings(list)
+	if err != nil {
+		return nil, err
+	}
+	reversed := make([]string, len(l))
+	for i, s := range l {
+		reversed[len(l)-1-i] = s
+	}
+	return reversed, nil
+}
+
+func uniq(list interface{}) ([]string, error) {
+	l, err := toStrings(list)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	out := make([]string, 0, len(l))
+	for _, s := range l {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out, nil
+}
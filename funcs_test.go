@@ -0,0 +1,92 @@
+package confclient
+
+import (
+	"bytes"
+	"testing"
+	"text/template"
+)
+
+func renderHelper(t *testing.T, tpl string, data interface{}) string {
+	tmpl, err := template.New("test").Funcs(FuncMap(InitiateClient("http://localhost:8080"))).Parse(tpl)
+	if err != nil {
+		t.Fatalf("Cannot parse %q: %s", tpl, err)
+	}
+	var buffer bytes.Buffer
+	if err := tmpl.Execute(&buffer, data); err != nil {
+		t.Fatalf("Cannot execute %q: %s", tpl, err)
+	}
+	return buffer.String()
+}
+
+func TestHelperFuncs(t *testing.T) {
+	data := map[string]interface{}{
+		"list":  []string{"b", "a", "c", "a"},
+		"port":  int64(6379),
+		"hash":  []KeyPair{{"z", "1", "s"}, {"a", "2", "s"}},
+		"empty": "",
+		"conf":  map[string]interface{}{"name": "web", "port": int64(80)},
+	}
+	tests := []struct {
+		tpl  string
+		want string
+	}{
+		{`{{"Hello" | upper}}`, "HELLO"},
+		{`{{"Hello" | lower}}`, "hello"},
+		{`{{"  x  " | trim}}`, "x"},
+		{`{{"www.example.com" | trimPrefix "www."}}`, "example.com"},
+		{`{{join "," (split ":" "a:b:c")}}`, "a,b,c"},
+		{`{{"a-b-c" | replace "-" "_"}}`, "a_b_c"},
+		{`{{"web01.ams1" | regexReplace "^([a-z]+)[0-9]+" "$1"}}`, "web.ams1"},
+		{`{{regexMatch "^web" "web01"}}`, "true"},
+		{`{{"a\nb" | indent 2}}`, "  a\n  b"},
+		{`{{.empty | default "fallback"}}`, "fallback"},
+		{`{{"set" | default "fallback"}}`, "set"},
+		{`{{coalesce .empty "" "second"}}`, "second"},
+		{`{{ternary "yes" "no" true}}`, "yes"},
+		{`{{add .port 1}}`, "6380"},
+		{`{{sub "10" 4}}`, "6"},
+		{`{{mul 3 4}} {{div 9 2}} {{mod 9 2}}`, "12 4 1"},
+		{`{{max 3 9}} {{min 3 9}}`, "9 3"},
+		{`{{toJSON .list}}`, `["b","a","c","a"]`},
+		{`{{toJSON .hash}}`, `{"a":"2","z":"1"}`},
+		{`{{toYAML .list}}`, "- b\n- a\n- c\n- a"},
+		{`{{toTOML .conf}}`, "name = \"web\"\nport = 80"},
+		{`{{"hello" | b64enc}}`, "aGVsbG8="},
+		{`{{"aGVsbG8=" | b64dec}}`, "hello"},
+		{`{{"abc" | sha256}}`, "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{`{{isIPv4 "10.0.0.1"}} {{isIPv6 "10.0.0.1"}} {{isIPv6 "fe80::1"}}`, "true false true"},
+		{`{{cidrContains "10.1.0.0/16" "10.1.2.3"}}`, "true"},
+		{`{{cidrNetwork "10.1.2.3/16"}} {{cidrNetmask "10.1.2.3/16"}}`, "10.1.0.0 255.255.0.0"},
+		{`{{cidrHost 1 "10.1.0.0/16"}} {{cidrHost -2 "10.1.0.0/16"}}`, "10.1.0.1 10.1.255.254"},
+		{`{{cidrHost 5 "2001:db8::/64"}}`, "2001:db8::5"},
+		{`{{cidrHost -1 "2001:db8::/64"}}`, "2001:db8::ffff:ffff:ffff:ffff"},
+		{`{{cidrHost -1 "::/0"}} {{cidrHost 1 "0.0.0.0/0"}}`, "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff 0.0.0.1"},
+		{`{{join "," (sortAlpha .list)}}`, "a,a,b,c"},
+		{`{{join "," (uniq .list)}}`, "b,a,c"},
+		{`{{join "," (reverse .list)}}`, "a,c,a,b"},
+		{`{{range sortHash .hash}}{{.Key}}={{.Value}} {{end}}`, "a=2 z=1 "},
+	}
+
+	for _, tt := range tests {
+		got := renderHelper(t, tt.tpl, data)
+		if got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.tpl, tt.want, got)
+		}
+	}
+}
+
+func TestHelperFuncErrors(t *testing.T) {
+	fm := FuncMap(InitiateClient("http://localhost:8080"))
+	for _, tpl := range []string{
+		`{{div 1 0}}`,
+		`{{add "x" 1}}`,
+		`{{cidrHost 300 "10.0.0.0/24"}}`,
+		`{{regexReplace "(" "" "x"}}`,
+	} {
+		tmpl := template.Must(template.New("test").Funcs(fm).Parse(tpl))
+		var buffer bytes.Buffer
+		if err := tmpl.Execute(&buffer, nil); err == nil {
+			t.Errorf("%s: expected an error", tpl)
+		}
+	}
+}
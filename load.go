@@ -0,0 +1,233 @@
+package confclient
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Load policies for keys which already exist on the server
+const (
+	LoadOverwrite    = "overwrite"
+	LoadSkipExisting = "skip-existing"
+	LoadMerge        = "merge"
+)
+
+// Load actions
+const (
+	LoadCreate    = "create"
+	LoadUpdate    = "update"
+	LoadUnchanged = "unchanged"
+	LoadSkip      = "skip"
+)
+
+type LoadOptions struct {
+	// Policy is one of LoadOverwrite, LoadSkipExisting or LoadMerge
+	Policy string
+	// Prefix only loads keys starting with it
+	Prefix string
+	// DryRun works out every action without writing anything
+	DryRun bool
+}
+
+// LoadAction is what was (or, in a dry run, would be) done with one key
+type LoadAction struct {
+	Key    string `json:"key"`
+	Action string `json:"action"`
+}
+
+type LoadResult struct {
+	Actions []LoadAction `json:"actions"`
+}
+
+// Count returns the number of keys with the given action
+func (r *LoadResult) Count(action string) int {
+	n := 0
+	for _, a := range r.Actions {
+		if a.Action == action {
+			n++
+		}
+	}
+	return n
+}
+
+// Summary is a one line count of each action
+func (r *LoadResult) Summary() string {
+	return fmt.Sprintf("%d created, %d updated, %d unchanged, %d skipped",
+		r.Count(LoadCreate), r.Count(LoadUpdate), r.Count(LoadUnchanged), r.Count(LoadSkip))
+}
+
+// ReadDumpDir reads a directory written by AdminDumpKeys. Each file holds one
+// key as JSON and is named after the key, see KeyFileName. Hidden files and
+// directories are ignored, and other files which do not hold a key are
+// skipped with a warning.
+func ReadDumpDir(path string) (map[string]KeyResponse, error) {
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]KeyResponse)
+	for _, fi := range files {
+		if strings.HasPrefix(fi.Name(), ".") || !fi.Mode().IsRegular() {
+			continue
+		}
+		file := filepath.Join(path, fi.Name())
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var kr KeyResponse
+		if err := json.Unmarshal(b, &kr); err != nil {
+			log.Warnf("Skipping %s: not a key file: %s", file, err)
+			continue
+		}
+		if kr, err = checkKey(kr); err != nil {
+			log.Warnf("Skipping %s: not a key file: %s", file, err)
+			continue
+		}
+		keys[KeyFromFileName(fi.Name())] = kr
+	}
+	return keys, nil
+}
+
+// AdminLoadKeys sets every key in a dump directory or archive, see ReadDump
+// and AdminLoadKeyMap
+func (c *Client) AdminLoadKeys(path string, opts LoadOptions) (*LoadResult, error) {
+	keys, err := ReadDump(path)
+	if err != nil {
+		return nil, err
+	}
+	return c.AdminLoadKeyMap(keys, opts)
+}
+
+// AdminLoadKeyMap sets keys on the server. Keys which do not exist are
+// created. Existing keys are overwritten, skipped or merged depending on
+// opts.Policy: merging adds the loaded hash fields and list items to the
+// existing key, and overwrites strings. Keys which would not change are
+// reported as unchanged and not written. Nothing is loaded if any value is
+// Redacted, as it would replace the real secret.
+func (c *Client) AdminLoadKeyMap(keys map[string]KeyResponse, opts LoadOptions) (*LoadResult, error) {
+	switch opts.Policy {
+	case LoadOverwrite, LoadSkipExisting, LoadMerge:
+	default:
+		return nil, fmt.Errorf("Unknown load policy '%s'", opts.Policy)
+	}
+	if redacted := redactedKeys(keys, opts.Prefix); len(redacted) > 0 {
+		return nil, fmt.Errorf("Keys hold %s values, which would replace the real secrets (export with -reveal): %s", Redacted, strings.Join(redacted, ", "))
+	}
+
+	result := &LoadResult{Actions: make([]LoadAction, 0, len(keys))}
+	for _, k := range sortedKeyNames(keys) {
+		if !strings.HasPrefix(k, opts.Prefix) {
+			continue
+		}
+		kr := keys[k]
+		action := LoadCreate
+
+		existing, err := c.AdminGetKey(k)
+		if err != nil && !IsNotFound(err) {
+			return result, fmt.Errorf("Cannot get key %s: %s", k, err)
+		}
+		if err == nil {
+			switch opts.Policy {
+			case LoadSkipExisting:
+				action = LoadSkip
+			case LoadMerge:
+				if kr, err = mergeKeyResponse(existing, kr); err != nil {
+					return result, fmt.Errorf("Cannot merge key %s: %s", k, err)
+				}
+				action = LoadUpdate
+			default:
+				action = LoadUpdate
+			}
+			if action == LoadUpdate && reflect.DeepEqual(existing, kr) {
+				action = LoadUnchanged
+			}
+		}
+
+		result.Actions = append(result.Actions, LoadAction{Key: k, Action: action})
+		if opts.DryRun || (action != LoadCreate && action != LoadUpdate) {
+			continue
+		}
+		jsonblob, err := json.Marshal(kr)
+		if err != nil {
+			return result, err
+		}
+		if err := c.AdminSetKeyFromJSON(k, jsonblob); err != nil {
+			return result, fmt.Errorf("Cannot set key %s: %s", k, err)
+		}
+		log.WithFields(log.Fields{"key": k, "action": action}).Debug("Loaded key")
+	}
+	return result, nil
+}
+
+// redactedKeys returns the keys starting with prefix which hold a Redacted
+// value
+func redactedKeys(keys map[string]KeyResponse, prefix string) []string {
+	redacted := make([]string, 0)
+	for _, k := range sortedKeyNames(keys) {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		found := false
+		mapKeyStrings(keys[k], func(field string, value string) (string, error) {
+			found = found || value == Redacted
+			return value, nil
+		})
+		if found {
+			redacted = append(redacted, k)
+		}
+	}
+	return redacted
+}
+
+// mergeKeyResponse adds the hash fields or list items of loaded to existing.
+// Strings are replaced.
+func mergeKeyResponse(existing KeyResponse, loaded KeyResponse) (KeyResponse, error) {
+	if existing.Type != loaded.Type {
+		return loaded, fmt.Errorf("key is a %s, not a %s", existing.Type, loaded.Type)
+	}
+	switch l := loaded.Data.(type) {
+	case map[string]interface{}:
+		e, _ := existing.Data.(map[string]interface{})
+		m := make(map[string]interface{})
+		for k, v := range e {
+			m[k] = v
+		}
+		for k, v := range l {
+			m[k] = v
+		}
+		return KeyResponse{Type: loaded.Type, Data: m}, nil
+	case []interface{}:
+		e, _ := existing.Data.([]interface{})
+		merged := append([]interface{}{}, e...)
+		for _, v := range l {
+			found := false
+			for _, ev := range e {
+				if reflect.DeepEqual(v, ev) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				merged = append(merged, v)
+			}
+		}
+		return KeyResponse{Type: loaded.Type, Data: merged}, nil
+	}
+	return loaded, nil
+}
+
+func sortedKeyNames(keys map[string]KeyResponse) []string {
+	names := make([]string, 0, len(keys))
+	for k := range keys {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,96 @@
+package confclient
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAdminLoadKeys(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "confadm")
+	defer os.RemoveAll(dir)
+	writeTestFile(t, filepath.Join(dir, "app:name"), `{"type":"string","data":"app"}`)
+	writeTestFile(t, filepath.Join(dir, "app:hosts"), `{"type":"list","data":["a","b"]}`)
+	writeTestFile(t, filepath.Join(dir, "app:db"), `{"type":"hash","data":{"host":"db1","port":"5432"}}`)
+	writeTestFile(t, filepath.Join(dir, "other:key"), `{"type":"string","data":"x"}`)
+
+	newKeys := func() map[string]KeyResponse {
+		return map[string]KeyResponse{
+			"app:name":  {"string", "app"},
+			"app:hosts": {"list", []interface{}{"b", "c"}},
+			"app:db":    {"hash", map[string]interface{}{"host": "db0", "user": "app"}},
+		}
+	}
+
+	for _, tc := range []struct {
+		policy  string
+		actions []LoadAction
+		db      map[string]interface{}
+		hosts   []interface{}
+	}{
+		{
+			policy:  LoadSkipExisting,
+			actions: []LoadAction{{"app:db", LoadSkip}, {"app:hosts", LoadSkip}, {"app:name", LoadSkip}},
+			db:      map[string]interface{}{"host": "db0", "user": "app"},
+			hosts:   []interface{}{"b", "c"},
+		},
+		{
+			policy:  LoadOverwrite,
+			actions: []LoadAction{{"app:db", LoadUpdate}, {"app:hosts", LoadUpdate}, {"app:name", LoadUnchanged}},
+			db:      map[string]interface{}{"host": "db1", "port": "5432"},
+			hosts:   []interface{}{"a", "b"},
+		},
+		{
+			policy:  LoadMerge,
+			actions: []LoadAction{{"app:db", LoadUpdate}, {"app:hosts", LoadUpdate}, {"app:name", LoadUnchanged}},
+			db:      map[string]interface{}{"host": "db1", "port": "5432", "user": "app"},
+			hosts:   []interface{}{"b", "c", "a"},
+		},
+	} {
+		keys := newKeys()
+		ts := newTreeServer(t, keys)
+		c := InitiateClient(ts.URL)
+
+		result, err := c.AdminLoadKeys(dir, LoadOptions{Policy: tc.policy, Prefix: "app:", DryRun: true})
+		if err != nil {
+			t.Fatalf("%s: %s", tc.policy, err)
+		}
+		if !reflect.DeepEqual(result.Actions, tc.actions) {
+			t.Errorf("%s: expected %v, got %v", tc.policy, tc.actions, result.Actions)
+		}
+		if !reflect.DeepEqual(keys, newKeys()) {
+			t.Errorf("%s: dry run changed keys: %v", tc.policy, keys)
+		}
+
+		if _, err := c.AdminLoadKeys(dir, LoadOptions{Policy: tc.policy, Prefix: "app:"}); err != nil {
+			t.Fatalf("%s: %s", tc.policy, err)
+		}
+		if !reflect.DeepEqual(keys["app:db"].Data, tc.db) || !reflect.DeepEqual(keys["app:hosts"].Data, tc.hosts) {
+			t.Errorf("%s: unexpected keys after load: %v", tc.policy, keys)
+		}
+		if _, ok := keys["other:key"]; ok {
+			t.Errorf("%s: key outside prefix was loaded", tc.policy)
+		}
+		ts.Close()
+	}
+}
+
+func TestAdminLoadKeysCreates(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "confadm")
+	defer os.RemoveAll(dir)
+	writeTestFile(t, filepath.Join(dir, "app:name"), `{"type":"string","data":"app"}`)
+
+	keys := map[string]KeyResponse{}
+	ts := newTreeServer(t, keys)
+	defer ts.Close()
+
+	result, err := InitiateClient(ts.URL).AdminLoadKeys(dir, LoadOptions{Policy: LoadSkipExisting})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Summary() != "1 created, 0 updated, 0 unchanged, 0 skipped" || keys["app:name"].Data != "app" {
+		t.Errorf("Unexpected load: %s %v", result.Summary(), keys)
+	}
+}
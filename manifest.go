@@ -0,0 +1,121 @@
+package confclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Manifest locations
+const (
+	ManifestDest  = "dest"
+	ManifestState = "state"
+)
+
+// Manifest records which keys an output file was rendered from
+type Manifest struct {
+	Dest      string      `json:"dest"`
+	Src       string      `json:"src"`
+	Config    string      `json:"config"`
+	Generated time.Time   `json:"generated"`
+	Lookups   []KeyLookup `json:"lookups"`
+}
+
+// Uses reports whether key was looked up for this file, either by name or
+// as the source a lookup resolved from (e.g. "global:db_host")
+func (m Manifest) Uses(key string) bool {
+	for _, l := range m.Lookups {
+		if l.Key == key {
+			return true
+		}
+		for _, source := range strings.Split(l.Source, ",") {
+			if source == key {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ManifestDir is where manifests are kept when they are stored in stateDir
+func ManifestDir(stateDir string) string {
+	return filepath.Join(stateDir, "manifests")
+}
+
+func (t *TemplateConfig) manifestPath() string {
+	if t.Manifest == ManifestState {
+		return filepath.Join(ManifestDir(t.StateDir), url.QueryEscape(t.Dest)+".json")
+	}
+	return t.Dest + ".manifest.json"
+}
+
+func (t *TemplateConfig) writeManifest(r *LookupRecorder) error {
+	m := Manifest{
+		Dest:      t.Dest,
+		Src:       t.Src,
+		Config:    t.ConfigName,
+		Generated: time.Now().UTC(),
+		Lookups:   r.Lookups(),
+	}
+	jsonblob, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := t.manifestPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("Cannot create manifest directory: %s", err)
+	}
+	// Readable by the same users as the file it describes
+	mode := t.FileMode.Perm()
+	if mode == 0 {
+		mode = 0600
+	}
+	tmp := path + ".tmp"
+	os.Remove(tmp)
+	if err := ioutil.WriteFile(tmp, jsonblob, mode); err != nil {
+		return fmt.Errorf("Cannot write manifest %s: %s", tmp, err)
+	}
+	// WriteFile's mode is subject to the umask
+	if err := os.Chmod(tmp, mode); err != nil {
+		return fmt.Errorf("Cannot chmod manifest %s: %s", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("Cannot rename %s to %s: %s", tmp, path, err)
+	}
+	return nil
+}
+
+// ReadManifests loads every manifest in dir, ordered by Dest
+func ReadManifests(dir string) ([]Manifest, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	manifests := make([]Manifest, 0, len(files))
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		var m Manifest
+		if err := json.Unmarshal(b, &m); err != nil {
+			return nil, fmt.Errorf("Cannot parse manifest %s: %s", f, err)
+		}
+		manifests = append(manifests, m)
+	}
+	sort.Sort(manifestsByDest(manifests))
+	return manifests, nil
+}
+
+type manifestsByDest []Manifest
+
+func (m manifestsByDest) Len() int           { return len(m) }
+func (m manifestsByDest) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+func (m manifestsByDest) Less(i, j int) bool { return m[i].Dest < m[j].Dest }
@@ -0,0 +1,72 @@
+package confclient
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestManifest(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/string/db_host":
+			w.Write([]byte(`{"type":"string","data":{"value":"db1","source":"global:db_host"}}`))
+		case "/string/db_password":
+			w.Write([]byte(`{"type":"string","data":{"value":"hunter22","source":"global:db_password"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	base, err := ioutil.TempDir("", "conftpl")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir: %s", err)
+	}
+	defer os.RemoveAll(base)
+	writeTestFile(t, filepath.Join(base, "app.tmpl"), `{{key "db_host"}} {{key "db_password"}} {{key "db_port" "5432"}}`)
+
+	c := InitiateClient(ts.URL)
+	tc := &TemplateConfig{
+		Src:        filepath.Join(base, "app.tmpl"),
+		ConfigName: "app",
+		Dest:       filepath.Join(base, "app.conf"),
+		TempDest:   filepath.Join(base, "app.conf.tmp"),
+		FileMode:   0640,
+		Uid:        os.Geteuid(),
+		Gid:        os.Getegid(),
+		StateDir:   filepath.Join(base, "state"),
+		Manifest:   ManifestState,
+	}
+	if err := tc.ProcessWithClient(c); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	manifests, err := ReadManifests(ManifestDir(tc.StateDir))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(manifests) != 1 || manifests[0].Dest != tc.Dest || len(manifests[0].Lookups) != 3 {
+		t.Fatalf("Unexpected manifests: %+v", manifests)
+	}
+	m := manifests[0]
+	if m.Lookups[0].Key != "db_host" || m.Lookups[0].Source != "global:db_host" || m.Lookups[0].ValueHash != valueHash("db1") {
+		t.Errorf("Unexpected lookup: %+v", m.Lookups[0])
+	}
+	if m.Lookups[1].ValueHash != "" {
+		t.Errorf("Expected no hash of a secret value: %+v", m.Lookups[1])
+	}
+	if !m.Lookups[2].Default {
+		t.Errorf("Expected db_port to be a default: %+v", m.Lookups[2])
+	}
+	files, _ := filepath.Glob(filepath.Join(ManifestDir(tc.StateDir), "*.json"))
+	if fi, err := os.Stat(files[0]); err != nil || fi.Mode().Perm() != 0640 {
+		t.Errorf("Expected the manifest to have the mode of its file, got %v: %v", fi.Mode(), err)
+	}
+	if !m.Uses("global:db_host") || !m.Uses("db_port") || m.Uses("global:other") {
+		t.Error("Uses does not match the recorded lookups")
+	}
+}
@@ -0,0 +1,176 @@
+package confclient
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// The hash and list changes below read the whole key, change it and write it
+// back IfUnchanged, so a key changed by someone else in the meantime is not
+// overwritten but returned as a *ConflictError.
+
+// AdminDeleteHashField removes fieldName from the hash keyName
+func (c *Client) AdminDeleteHashField(keyName string, fieldName string) error {
+	kr, version, err := c.adminGetTyped(keyName, "hash")
+	if err != nil {
+		return err
+	}
+	fields, _ := kr.Data.(map[string]interface{})
+	if _, ok := fields[fieldName]; !ok {
+		return fmt.Errorf("Hash %s has no field %s", keyName, fieldName)
+	}
+	m := make(map[string]interface{})
+	for k, v := range fields {
+		if k != fieldName {
+			m[k] = v
+		}
+	}
+	return c.IfUnchanged(version).adminSetKey(keyName, KeyResponse{Type: "hash", Data: m})
+}
+
+// AdminListSet replaces the item at index of the list keyName. Negative
+// indexes count from the end, -1 being the last item.
+func (c *Client) AdminListSet(keyName string, index int, value string) error {
+	return c.adminUpdateList(keyName, false, func(l []interface{}) ([]interface{}, error) {
+		i, err := listIndex(keyName, index, len(l))
+		if err != nil {
+			return nil, err
+		}
+		l[i] = value
+		return l, nil
+	})
+}
+
+// AdminListInsert inserts value before the item at index of the list
+// keyName, or appends it if index is the length of the list. Negative indexes
+// count from the end.
+func (c *Client) AdminListInsert(keyName string, index int, value string) error {
+	return c.adminUpdateList(keyName, false, func(l []interface{}) ([]interface{}, error) {
+		i := index
+		if index != len(l) {
+			var err error
+			if i, err = listIndex(keyName, index, len(l)); err != nil {
+				return nil, err
+			}
+		}
+		l = append(l, nil)
+		copy(l[i+1:], l[i:])
+		l[i] = value
+		return l, nil
+	})
+}
+
+// AdminListPrepend adds value to the start of the list keyName, creating the
+// list if it does not exist. AdminListAppend adds to the end.
+func (c *Client) AdminListPrepend(keyName string, value string) error {
+	return c.adminUpdateList(keyName, true, func(l []interface{}) ([]interface{}, error) {
+		return append([]interface{}{value}, l...), nil
+	})
+}
+
+// AdminListRemove removes items equal to value from the list keyName and
+// returns how many were removed. Like Redis LREM, a positive count removes
+// at most that many from the start, a negative count from the end and 0
+// removes every match.
+func (c *Client) AdminListRemove(keyName string, count int, value string) (int, error) {
+	removed := 0
+	err := c.adminUpdateList(keyName, false, func(l []interface{}) ([]interface{}, error) {
+		keep := make([]bool, len(l))
+		for i := range l {
+			keep[i] = true
+		}
+		for n := 0; n < len(l); n++ {
+			i := n
+			if count < 0 {
+				i = len(l) - 1 - n
+			}
+			if l[i] == value && (count == 0 || removed < abs(count)) {
+				keep[i] = false
+				removed++
+			}
+		}
+		out := make([]interface{}, 0, len(l)-removed)
+		for i, item := range l {
+			if keep[i] {
+				out = append(out, item)
+			}
+		}
+		return out, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+// AdminListPop removes and returns the first item of the list keyName
+func (c *Client) AdminListPop(keyName string) (string, error) {
+	var item string
+	err := c.adminUpdateList(keyName, false, func(l []interface{}) ([]interface{}, error) {
+		if len(l) == 0 {
+			return nil, fmt.Errorf("List %s is empty", keyName)
+		}
+		item = fmt.Sprint(l[0])
+		return l[1:], nil
+	})
+	return item, err
+}
+
+// adminGetTyped gets keyName and its version for an update, and checks it
+// is of type ktype
+func (c *Client) adminGetTyped(keyName string, ktype string) (KeyResponse, string, error) {
+	kr, version, err := c.adminGetForUpdate(keyName)
+	if IsNotFound(err) {
+		return kr, version, fmt.Errorf("Cannot get key %s: %s", keyName, err)
+	}
+	if err != nil {
+		return kr, version, err
+	}
+	if kr.Type != ktype {
+		return kr, version, fmt.Errorf("Key %s is a %s, not a %s", keyName, kr.Type, ktype)
+	}
+	return kr, version, nil
+}
+
+// adminUpdateList changes the items of the list keyName with fn and writes
+// the result back. If create is set a missing key is an empty list.
+func (c *Client) adminUpdateList(keyName string, create bool, fn func([]interface{}) ([]interface{}, error)) error {
+	items := make([]interface{}, 0)
+	kr, version, err := c.adminGetTyped(keyName, "list")
+	switch {
+	case err == nil:
+		l, _ := kr.Data.([]interface{})
+		items = append(items, l...)
+	case !(create && IsNotFound(err)):
+		return err
+	}
+
+	items, err = fn(items)
+	if err != nil {
+		return err
+	}
+	if version != VersionAbsent && reflect.DeepEqual(items, kr.Data) {
+		return nil
+	}
+	return c.IfUnchanged(version).adminSetKey(keyName, KeyResponse{Type: "list", Data: items})
+}
+
+// listIndex turns index, which may count from the end if negative, into a
+// position in a list of length n
+func listIndex(keyName string, index int, n int) (int, error) {
+	i := index
+	if i < 0 {
+		i += n
+	}
+	if i < 0 || i >= n {
+		return 0, fmt.Errorf("Index %d out of range for list %s of length %d", index, keyName, n)
+	}
+	return i, nil
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
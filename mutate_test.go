@@ -0,0 +1,62 @@
+package confclient
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHashAndListMutations(t *testing.T) {
+	keys := map[string]KeyResponse{
+		"app:db":    {"hash", map[string]interface{}{"host": "db1", "port": "5432"}},
+		"app:hosts": {"list", []interface{}{"a", "b", "a", "c", "a"}},
+	}
+	ts := newTreeServer(t, keys)
+	defer ts.Close()
+	c := InitiateClient(ts.URL)
+
+	if err := c.AdminDeleteHashField("app:db", "port"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(keys["app:db"].Data, map[string]interface{}{"host": "db1"}) {
+		t.Errorf("Unexpected hash %v", keys["app:db"])
+	}
+	if err := c.AdminDeleteHashField("app:db", "port"); err == nil {
+		t.Errorf("Expected an error deleting a missing field")
+	}
+
+	removed, err := c.AdminListRemove("app:hosts", -2, "a")
+	if err != nil || removed != 2 {
+		t.Fatalf("Expected 2 items removed, got %d: %v", removed, err)
+	}
+	steps := []func() error{
+		func() error { return c.AdminListSet("app:hosts", -1, "d") },
+		func() error { return c.AdminListInsert("app:hosts", 1, "x") },
+		func() error { return c.AdminListInsert("app:hosts", 4, "z") },
+		func() error { return c.AdminListPrepend("app:hosts", "first") },
+		func() error { return c.AdminListAppend("app:hosts", "last") },
+		func() error { return c.AdminListPrepend("app:new", "only") },
+	}
+	for i, step := range steps {
+		if err := step(); err != nil {
+			t.Fatalf("Step %d: %s", i, err)
+		}
+	}
+	item, err := c.AdminListPop("app:hosts")
+	if err != nil || item != "first" {
+		t.Errorf("Expected to pop 'first', got %q: %v", item, err)
+	}
+
+	expected := []interface{}{"a", "x", "b", "d", "z", "last"}
+	if !reflect.DeepEqual(keys["app:hosts"].Data, expected) {
+		t.Errorf("Expected %v, got %v", expected, keys["app:hosts"].Data)
+	}
+	if !reflect.DeepEqual(keys["app:new"], KeyResponse{"list", []interface{}{"only"}}) {
+		t.Errorf("Unexpected new list %v", keys["app:new"])
+	}
+	if err := c.AdminListSet("app:hosts", 6, "x"); err == nil {
+		t.Errorf("Expected an error for an index out of range")
+	}
+	if err := c.AdminListSet("app:db", 0, "x"); err == nil {
+		t.Errorf("Expected an error setting a list item of a hash")
+	}
+}
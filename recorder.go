@@ -0,0 +1,175 @@
+package confclient
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// KeyLookup records a single key lookup made through the template functions
+type KeyLookup struct {
+	Key    string `json:"key"`
+	Type   string `json:"type"`
+	Source string `json:"source"`
+	// Default is set when the key was not found and the default was used
+	Default bool `json:"default,omitempty"`
+	// ValueHash is the sha256 of the JSON encoded value, so changed values
+	// can be detected without storing them. It is empty for secret values,
+	// which could otherwise be guessed offline from their hash.
+	ValueHash string `json:"value_hash,omitempty"`
+}
+
+// LookupRecorder collects the key lookups of one or more clients. It is safe
+// for concurrent use.
+type LookupRecorder struct {
+	mu      sync.Mutex
+	lookups []KeyLookup
+}
+
+func NewLookupRecorder() *LookupRecorder {
+	return &LookupRecorder{}
+}
+
+func (r *LookupRecorder) add(l KeyLookup) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lookups = append(r.lookups, l)
+}
+
+// Lookups returns every distinct lookup recorded so far, ordered by key
+func (r *LookupRecorder) Lookups() []KeyLookup {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	seen := make(map[KeyLookup]bool)
+	lookups := make([]KeyLookup, 0, len(r.lookups))
+	for _, l := range r.lookups {
+		if !seen[l] {
+			seen[l] = true
+			lookups = append(lookups, l)
+		}
+	}
+	sort.Sort(lookupsByKey(lookups))
+	return lookups
+}
+
+type lookupsByKey []KeyLookup
+
+func (l lookupsByKey) Len() int      { return len(l) }
+func (l lookupsByKey) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l lookupsByKey) Less(i, j int) bool {
+	if l[i].Key != l[j].Key {
+		return l[i].Key < l[j].Key
+	}
+	return l[i].Source < l[j].Source
+}
+
+// Report formats the recorded lookups as an aligned table
+func (r *LookupRecorder) Report() string {
+	lookups := r.Lookups()
+	width := len("KEY")
+	for _, l := range lookups {
+		if len(l.Key) > width {
+			width = len(l.Key)
+		}
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%-*s  %-6s  %s\n", width, "KEY", "TYPE", "SOURCE")
+	for _, l := range lookups {
+		fmt.Fprintf(&b, "%-*s  %-6s  %s\n", width, l.Key, l.Type, l.Source)
+	}
+	return b.String()
+}
+
+// WithRecorder returns a copy of the client which also records its lookups
+// in r, in addition to any recorders the client already has
+func (c *Client) WithRecorder(r *LookupRecorder) *Client {
+	recorded := *c
+	recorded.recorders = append(append([]*LookupRecorder{}, c.recorders...), r)
+	return &recorded
+}
+
+func (c *Client) record(l KeyLookup) {
+	for _, r := range c.recorders {
+		r.add(l)
+	}
+}
+
+// joinSources returns the distinct sources in the order they were first seen
+func joinSources(sources []string) string {
+	seen := make(map[string]bool)
+	var distinct []string
+	for _, s := range sources {
+		if !seen[s] {
+			seen[s] = true
+			distinct = append(distinct, s)
+		}
+	}
+	return strings.Join(distinct, ",")
+}
+
+func valueHash(v interface{}) string {
+	b, _ := json.Marshal(v)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *Client) recordString(key string, source string, value string, isDefault bool) {
+	if len(c.recorders) == 0 {
+		return
+	}
+	l := KeyLookup{Key: key, Type: "string", Source: source, Default: isDefault}
+	if !c.secretLookup && !IsSecretValue(key, "", value) {
+		l.ValueHash = valueHash(value)
+	}
+	c.record(l)
+}
+
+func (c *Client) recordList(key string, data []ValueSource) {
+	if len(c.recorders) == 0 {
+		return
+	}
+	sources := make([]string, len(data))
+	values := make([]string, len(data))
+	secret := c.secretLookup
+	for i, v := range data {
+		sources[i] = v.Source
+		values[i] = v.Value
+		secret = secret || IsSecretValue(key, "", v.Value)
+	}
+	l := KeyLookup{Key: key, Type: "list", Source: joinSources(sources)}
+	if !secret {
+		l.ValueHash = valueHash(values)
+	}
+	c.record(l)
+}
+
+func (c *Client) recordHash(key string, kps []KeyPair) {
+	if len(c.recorders) == 0 {
+		return
+	}
+	sources := make([]string, 0, len(kps))
+	values := make(map[string]string)
+	secret := c.secretLookup
+	for _, kp := range sortHash(kps) {
+		sources = append(sources, kp.Source)
+		values[kp.Key] = kp.Value
+		secret = secret || IsSecretValue(key, kp.Key, kp.Value)
+	}
+	l := KeyLookup{Key: key, Type: "hash", Source: joinSources(sources)}
+	if !secret {
+		l.ValueHash = valueHash(values)
+	}
+	c.record(l)
+}
+
+// strictDefaultError names only the key, as the template's default may be a
+// secret which must not end up in logs.
+func strictDefaultError(key string, err error) error {
+	return fmt.Errorf("key '%s' not found (%s) and strict mode forbids falling back to its default", key, err)
+}
@@ -0,0 +1,55 @@
+package confclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStrictModeAndRecorder(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/string/db_host":
+			w.Write([]byte(`{"type":"string","data":{"value":"db1","source":"global:db_host"}}`))
+		case "/list/backends":
+			w.Write([]byte(`{"type":"list","data":[{"value":"a","source":"sites:ams1:backends"},{"value":"b","source":"sites:ams1:backends"}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	recorder := NewLookupRecorder()
+	c := InitiateClient(ts.URL).WithRecorder(recorder)
+
+	if val, err := c.GetStringValue("db/pasword", "x"); err != nil || val != "x" {
+		t.Errorf("Expected default without strict mode, got '%s': %v", val, err)
+	}
+	c.GetStringValue("db_host")
+	c.GetListValue("backends")
+
+	lookups := recorder.Lookups()
+	if len(lookups) != 3 {
+		t.Fatalf("Expected 3 lookups, got %+v", lookups)
+	}
+	if lookups[0].Key != "backends" || lookups[0].Source != "sites:ams1:backends" {
+		t.Errorf("Unexpected list lookup: %+v", lookups[0])
+	}
+	if !lookups[1].Default || lookups[2].Source != "global:db_host" {
+		t.Errorf("Unexpected string lookups: %+v", lookups[1:])
+	}
+	if !strings.Contains(recorder.Report(), "db/pasword  string  __DEFAULT__") {
+		t.Errorf("Unexpected report:\n%s", recorder.Report())
+	}
+
+	c.Strict = true
+	if _, err := c.GetStringValue("db/pasword", "s3cret"); err == nil || !strings.Contains(err.Error(), "strict mode") {
+		t.Errorf("Expected strict mode error, got: %v", err)
+	} else if strings.Contains(err.Error(), "s3cret") {
+		t.Errorf("Strict mode error leaks the default: %v", err)
+	}
+	if _, err := c.GetStringValueDebug("db/pasword", "x"); err == nil {
+		t.Error("Expected strict mode error from keyd")
+	}
+}
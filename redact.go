@@ -0,0 +1,122 @@
+package confclient
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Redacted replaces secret values in logs and confadm output
+const Redacted = "[REDACTED]"
+
+// minSecretLength avoids redacting every occurrence of very short values
+// such as "1" or "no" from all log lines
+const minSecretLength = 4
+
+var redactor = &secretRedactor{secrets: make(map[string]bool)}
+
+type secretRedactor struct {
+	mu      sync.RWMutex
+	secrets map[string]bool
+	ordered []string
+}
+
+// RegisterSecret makes the redaction hook replace value in every log line
+func RegisterSecret(value string) {
+	if len(value) < minSecretLength {
+		return
+	}
+	redactor.mu.Lock()
+	defer redactor.mu.Unlock()
+	if redactor.secrets[value] {
+		return
+	}
+	redactor.secrets[value] = true
+	redactor.ordered = append(redactor.ordered, value)
+	// Longest first, so a secret containing another is replaced whole
+	sort.Sort(byLengthDesc(redactor.ordered))
+}
+
+type byLengthDesc []string
+
+func (b byLengthDesc) Len() int           { return len(b) }
+func (b byLengthDesc) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byLengthDesc) Less(i, j int) bool { return len(b[i]) > len(b[j]) }
+
+// RedactString replaces every registered secret in s
+func RedactString(s string) string {
+	redactor.mu.RLock()
+	defer redactor.mu.RUnlock()
+	for _, secret := range redactor.ordered {
+		if strings.Contains(s, secret) {
+			s = strings.Replace(s, secret, Redacted, -1)
+		}
+	}
+	return s
+}
+
+// RedactHook is a logrus hook which redacts registered secrets from the
+// message and string fields of every log entry
+type RedactHook struct{}
+
+func (h RedactHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h RedactHook) Fire(entry *log.Entry) error {
+	entry.Message = RedactString(entry.Message)
+	for k, v := range entry.Data {
+		switch val := v.(type) {
+		case string:
+			entry.Data[k] = RedactString(val)
+		case error:
+			entry.Data[k] = RedactString(val.Error())
+		}
+	}
+	return nil
+}
+
+var installRedactHook sync.Once
+
+// IsSecretValue reports whether value, read from keyName (and fieldName for
+// a hash field), is redacted: the key or field name marks it as secret or the
+// value is encrypted
+func IsSecretValue(keyName string, fieldName string, value string) bool {
+	return IsSecretKey(keyName) || (fieldName != "" && IsSecretKey(fieldName)) || IsEncrypted(value)
+}
+
+// registerLookedUpSecret registers a looked up value with the redactor if
+// its key name marks it as secret or it is encrypted
+func registerLookedUpSecret(name string, value string) {
+	if IsSecretValue(name, "", value) {
+		RegisterSecret(value)
+	}
+}
+
+// registerKeySecrets registers the values of a key fetched through the admin
+// API which RedactKeyResponse would redact
+func registerKeySecrets(keyName string, kr KeyResponse) {
+	mapKeyStrings(kr, func(field string, value string) (string, error) {
+		if IsSecretValue(keyName, field, value) {
+			RegisterSecret(value)
+		}
+		return value, nil
+	})
+}
+
+// RedactKeyResponse returns a copy of kr with secret values replaced. Strings
+// and lists are redacted entirely when the key name is secret, hash fields
+// when the key or field name is secret. Encrypted values are always
+// redacted. The second return value reports whether anything was redacted.
+func RedactKeyResponse(keyName string, kr KeyResponse) (KeyResponse, bool) {
+	redacted := false
+	out, _ := mapKeyStrings(kr, func(field string, value string) (string, error) {
+		if IsSecretValue(keyName, field, value) {
+			redacted = true
+			return Redacted, nil
+		}
+		return value, nil
+	})
+	return out, redacted
+}
@@ -0,0 +1,361 @@
+package confclient
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/nacl/secretbox"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// EncryptedPrefix marks values sealed with EncryptValue
+const EncryptedPrefix = "enc:v1:"
+
+// SecretKeyPatterns are matched against the last part of a key name (or a
+// hash field name). Keys matching any of them are treated as secrets.
+var SecretKeyPatterns = []string{"password", "passwd", "secret", "token", "private_key", "api_key", "apikey"}
+
+// ErrNoSecretKey is returned when an encrypted value is found but no secret
+// key was loaded
+var ErrNoSecretKey = errors.New("value is encrypted but no secret key is loaded")
+
+// IsSecretKey reports whether a key or hash field name looks like it holds a
+// secret, e.g. "db:password" or "api/token"
+func IsSecretKey(name string) bool {
+	if i := strings.LastIndexAny(name, ":/"); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.ToLower(name)
+	for _, p := range SecretKeyPatterns {
+		if strings.Contains(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsEncrypted reports whether value was sealed with EncryptValue
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, EncryptedPrefix)
+}
+
+type sealedRecipient struct {
+	KeyId        string `json:"kid"`
+	EphemeralKey []byte `json:"epk"`
+	Nonce        []byte `json:"nonce"`
+	DataKey      []byte `json:"key"`
+}
+
+type sealedValue struct {
+	Recipients []sealedRecipient `json:"recipients"`
+	Nonce      []byte            `json:"nonce"`
+	Data       []byte            `json:"data"`
+}
+
+// KeyId identifies a public key in encrypted values
+func KeyId(publicKey *[32]byte) string {
+	sum := sha256.Sum256(publicKey[:])
+	return hex.EncodeToString(sum[:8])
+}
+
+// GenerateSecretKey returns a new NaCl box key pair
+func GenerateSecretKey() (publicKey, privateKey *[32]byte, err error) {
+	return box.GenerateKey(rand.Reader)
+}
+
+// ReadKeyFile reads a base64 encoded 32 byte key, as written by confadm
+// keygen, from path
+func ReadKeyFile(path string) (*[32]byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(raw) != 32 {
+		return nil, fmt.Errorf("%s is not a base64 encoded 32 byte key", path)
+	}
+	var key [32]byte
+	copy(key[:], raw)
+	return &key, nil
+}
+
+// WriteKeyFile writes key base64 encoded to path
+func WriteKeyFile(path string, key *[32]byte, mode os.FileMode) error {
+	return ioutil.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key[:])+"\n"), mode)
+}
+
+// EncryptValue seals value so that any of the recipients' private keys can
+// decrypt it. The value is encrypted with a random data key, which is sealed
+// to each recipient with an ephemeral NaCl box.
+func EncryptValue(value string, recipients []*[32]byte) (string, error) {
+	if len(recipients) == 0 {
+		return "", errors.New("no recipients to encrypt for")
+	}
+	var dataKey [32]byte
+	var nonce [24]byte
+	if _, err := rand.Read(dataKey[:]); err != nil {
+		return "", err
+	}
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return "", err
+	}
+	sv := sealedValue{
+		Nonce: nonce[:],
+		Data:  secretbox.Seal(nil, []byte(value), &nonce, &dataKey),
+	}
+	for _, r := range recipients {
+		ephPub, ephPriv, err := box.GenerateKey(rand.Reader)
+		if err != nil {
+			return "", err
+		}
+		var rnonce [24]byte
+		if _, err := rand.Read(rnonce[:]); err != nil {
+			return "", err
+		}
+		sv.Recipients = append(sv.Recipients, sealedRecipient{
+			KeyId:        KeyId(r),
+			EphemeralKey: ephPub[:],
+			Nonce:        rnonce[:],
+			DataKey:      box.Seal(nil, dataKey[:], &rnonce, r, ephPriv),
+		})
+	}
+	b, err := json.Marshal(sv)
+	if err != nil {
+		return "", err
+	}
+	return EncryptedPrefix + base64.StdEncoding.EncodeToString(b), nil
+}
+
+func parseSealedValue(value string) (sealedValue, error) {
+	var sv sealedValue
+	if !IsEncrypted(value) {
+		return sv, errors.New("value is not encrypted")
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, EncryptedPrefix))
+	if err != nil {
+		return sv, fmt.Errorf("malformed encrypted value: %s", err)
+	}
+	if err := json.Unmarshal(b, &sv); err != nil {
+		return sv, fmt.Errorf("malformed encrypted value: %s", err)
+	}
+	if len(sv.Nonce) != 24 {
+		return sv, errors.New("malformed encrypted value: bad nonce")
+	}
+	return sv, nil
+}
+
+// DecryptValue opens a value sealed with EncryptValue using privateKey
+func DecryptValue(value string, privateKey *[32]byte) (string, error) {
+	sv, err := parseSealedValue(value)
+	if err != nil {
+		return "", err
+	}
+	var publicKey [32]byte
+	curve25519.ScalarBaseMult(&publicKey, privateKey)
+	kid := KeyId(&publicKey)
+
+	for _, r := range sv.Recipients {
+		if r.KeyId != kid || len(r.EphemeralKey) != 32 || len(r.Nonce) != 24 {
+			continue
+		}
+		var ephPub [32]byte
+		var rnonce [24]byte
+		copy(ephPub[:], r.EphemeralKey)
+		copy(rnonce[:], r.Nonce)
+		dataKey, ok := box.Open(nil, r.DataKey, &rnonce, &ephPub, privateKey)
+		if !ok || len(dataKey) != 32 {
+			return "", errors.New("cannot decrypt value: data key does not open")
+		}
+		var key [32]byte
+		var nonce [24]byte
+		copy(key[:], dataKey)
+		copy(nonce[:], sv.Nonce)
+		plain, ok := secretbox.Open(nil, sv.Data, &nonce, &key)
+		if !ok {
+			return "", errors.New("cannot decrypt value: data does not open")
+		}
+		return string(plain), nil
+	}
+	return "", fmt.Errorf("cannot decrypt value: not encrypted for key %s", kid)
+}
+
+// EncryptedFor returns the key ids an encrypted value is sealed to
+func EncryptedFor(value string) ([]string, error) {
+	sv, err := parseSealedValue(value)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(sv.Recipients))
+	for i, r := range sv.Recipients {
+		ids[i] = r.KeyId
+	}
+	return ids, nil
+}
+
+// ReadRecipients reads the public key files values are encrypted for
+func ReadRecipients(paths []string) ([]*[32]byte, error) {
+	recipients := make([]*[32]byte, 0, len(paths))
+	for _, path := range paths {
+		key, err := ReadKeyFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read recipient key: %s", err)
+		}
+		recipients = append(recipients, key)
+	}
+	return recipients, nil
+}
+
+// mapKeyStrings returns a copy of kr with fn applied to every string value.
+// field is the hash field name, or "" for strings and list items.
+func mapKeyStrings(kr KeyResponse, fn func(field string, value string) (string, error)) (KeyResponse, error) {
+	out := KeyResponse{Type: kr.Type, Data: kr.Data}
+	var err error
+	switch d := kr.Data.(type) {
+	case string:
+		out.Data, err = fn("", d)
+	case []interface{}:
+		l := make([]interface{}, len(d))
+		for i, v := range d {
+			l[i] = v
+			if s, ok := v.(string); ok {
+				if l[i], err = fn("", s); err != nil {
+					break
+				}
+			}
+		}
+		out.Data = l
+	case map[string]interface{}:
+		m := make(map[string]interface{})
+		for k, v := range d {
+			m[k] = v
+			if s, ok := v.(string); ok {
+				if m[k], err = fn(k, s); err != nil {
+					break
+				}
+			}
+		}
+		out.Data = m
+	}
+	return out, err
+}
+
+// EncryptKeyResponse encrypts every value of a string, list or hash key for
+// recipients. Values which are already encrypted are left alone.
+func EncryptKeyResponse(kr KeyResponse, recipients []*[32]byte) (KeyResponse, error) {
+	return mapKeyStrings(kr, func(field string, value string) (string, error) {
+		if IsEncrypted(value) {
+			return value, nil
+		}
+		return EncryptValue(value, recipients)
+	})
+}
+
+// AdminReencryptKeys decrypts the encrypted values of every key matching
+// pattern with the loaded secret key and encrypts them again for recipients.
+// Keys without encrypted values are not written. It returns the number of
+// keys rewritten.
+func (c *Client) AdminReencryptKeys(pattern string, recipients []*[32]byte) (int, error) {
+	if c.secretKey == nil {
+		return 0, ErrNoSecretKey
+	}
+	keys, err := c.AdminListKeys(pattern)
+	if err != nil {
+		return 0, err
+	}
+	sort.Strings(keys)
+
+	count := 0
+	for _, k := range keys {
+		jsonblob, err := c.AdminGetKeyAsJSON(k)
+		if err != nil {
+			return count, fmt.Errorf("Cannot get key %s: %s", k, err)
+		}
+		var kr KeyResponse
+		if err := json.Unmarshal(jsonblob, &kr); err != nil {
+			return count, fmt.Errorf("Cannot parse key %s: %s", k, err)
+		}
+		changed := false
+		kr, err = mapKeyStrings(kr, func(field string, value string) (string, error) {
+			if !IsEncrypted(value) {
+				return value, nil
+			}
+			changed = true
+			plain, err := c.Decrypt(value)
+			if err != nil {
+				return "", err
+			}
+			return EncryptValue(plain, recipients)
+		})
+		if err != nil {
+			return count, fmt.Errorf("Cannot reencrypt key %s: %s", k, err)
+		}
+		if !changed {
+			continue
+		}
+		if jsonblob, err = json.Marshal(kr); err != nil {
+			return count, err
+		}
+		if err := c.AdminSetKeyFromJSON(k, jsonblob); err != nil {
+			return count, fmt.Errorf("Cannot set key %s: %s", k, err)
+		}
+		log.WithFields(log.Fields{"key": k}).Info("Reencrypted key")
+		count++
+	}
+	return count, nil
+}
+
+// LoadSecretKey reads the private key used to decrypt encrypted values
+func (c *Client) LoadSecretKey(path string) error {
+	key, err := ReadKeyFile(path)
+	if err != nil {
+		return fmt.Errorf("Cannot load secret key: %s", err)
+	}
+	c.secretKey = key
+	return nil
+}
+
+// Decrypt returns value decrypted if it is encrypted, and value otherwise.
+// Both are registered with the log redactor.
+func (c *Client) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	RegisterSecret(value)
+	if c.secretKey == nil {
+		return "", ErrNoSecretKey
+	}
+	plain, err := DecryptValue(value, c.secretKey)
+	if err != nil {
+		return "", err
+	}
+	RegisterSecret(plain)
+	return plain, nil
+}
+
+// GetSecretValue looks up a string key like GetStringValue and decrypts it.
+// The value is always treated as a secret, whatever the key is called.
+func (c *Client) GetSecretValue(key string, v ...string) (string, error) {
+	secret := *c
+	secret.secretLookup = true
+	value, err := secret.GetStringValue(key, v...)
+	if err != nil {
+		return "", err
+	}
+	RegisterSecret(value)
+	plain, err := c.Decrypt(value)
+	if err != nil {
+		return "", fmt.Errorf("Cannot decrypt key %s: %s", key, err)
+	}
+	log.WithFields(log.Fields{"key": key}).Debug("Got secret val")
+	return plain, nil
+}
@@ -0,0 +1,159 @@
+package confclient
+
+import (
+	"bytes"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	pub1, priv1, _ := GenerateSecretKey()
+	pub2, priv2, _ := GenerateSecretKey()
+	_, other, _ := GenerateSecretKey()
+
+	enc, err := EncryptValue("s3cr3t-value", []*[32]byte{pub1, pub2})
+	if err != nil {
+		t.Fatalf("Cannot encrypt: %s", err)
+	}
+	if !IsEncrypted(enc) || strings.Contains(enc, "s3cr3t") {
+		t.Fatalf("Unexpected encrypted value: %s", enc)
+	}
+	for _, priv := range []*[32]byte{priv1, priv2} {
+		if plain, err := DecryptValue(enc, priv); err != nil || plain != "s3cr3t-value" {
+			t.Errorf("Expected 's3cr3t-value', got '%s': %v", plain, err)
+		}
+	}
+	if _, err := DecryptValue(enc, other); err == nil {
+		t.Errorf("Expected error decrypting with a key that is not a recipient")
+	}
+	ids, _ := EncryptedFor(enc)
+	if len(ids) != 2 || ids[0] != KeyId(pub1) || ids[1] != KeyId(pub2) {
+		t.Errorf("Unexpected recipients: %v", ids)
+	}
+}
+
+func TestIsSecretKey(t *testing.T) {
+	for name, want := range map[string]bool{
+		"db:password":       true,
+		"api/TOKEN":         true,
+		"sites:ams1:apikey": true,
+		"db:host":           false,
+		"password:host":     false,
+	} {
+		if got := IsSecretKey(name); got != want {
+			t.Errorf("IsSecretKey(%s): expected %v, got %v", name, want, got)
+		}
+	}
+}
+
+func TestRedactKeyResponse(t *testing.T) {
+	kr := KeyResponse{Type: "hash", Data: map[string]interface{}{
+		"user":     "app",
+		"password": "hunter22",
+		"dsn":      EncryptedPrefix + "abcd",
+	}}
+	out, redacted := RedactKeyResponse("db", kr)
+	m := out.Data.(map[string]interface{})
+	if !redacted || m["user"] != "app" || m["password"] != Redacted || m["dsn"] != Redacted {
+		t.Errorf("Unexpected redacted hash: %v", m)
+	}
+	if kr.Data.(map[string]interface{})["password"] != "hunter22" {
+		t.Errorf("RedactKeyResponse modified its argument")
+	}
+
+	out, redacted = RedactKeyResponse("db:token", KeyResponse{Type: "list", Data: []interface{}{"a", "b"}})
+	if !redacted || fmt.Sprint(out.Data) != "[[REDACTED] [REDACTED]]" {
+		t.Errorf("Unexpected redacted list: %v", out.Data)
+	}
+	if _, redacted = RedactKeyResponse("db:host", KeyResponse{Type: "string", Data: "db1"}); redacted {
+		t.Errorf("Expected db:host not to be redacted")
+	}
+}
+
+func TestSecretFuncAndLogRedaction(t *testing.T) {
+	pub, priv, _ := GenerateSecretKey()
+	enc, _ := EncryptValue("plain-db-pass", []*[32]byte{pub})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/string/db:password":
+			w.Write([]byte(`{"type":"string","data":{"value":"` + enc + `","source":"global:db:password"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	dir, _ := ioutil.TempDir("", "conftpl")
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "secret.key")
+	WriteKeyFile(keyFile, priv, 0600)
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	lvl := log.GetLevel()
+	log.SetLevel(log.DebugLevel)
+	defer log.SetLevel(lvl)
+
+	c := InitiateClient(ts.URL)
+	if _, err := c.GetSecretValue("db:password"); err == nil || !strings.Contains(err.Error(), ErrNoSecretKey.Error()) {
+		t.Errorf("Expected ErrNoSecretKey without a key, got %v", err)
+	}
+	if err := c.LoadSecretKey(keyFile); err != nil {
+		t.Fatalf("Cannot load key: %s", err)
+	}
+	tmpl := template.Must(template.New("test").Funcs(FuncMap(c)).Parse(`{{ secret "db:password" }}`))
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, nil); err != nil || out.String() != "plain-db-pass" {
+		t.Errorf("Expected decrypted value, got '%s': %v", out.String(), err)
+	}
+
+	log.WithFields(log.Fields{"value": "plain-db-pass"}).Infof("leaking %s", enc)
+	if strings.Contains(buf.String(), "plain-db-pass") || strings.Contains(buf.String(), enc) {
+		t.Errorf("Secret not redacted from logs:\n%s", buf.String())
+	}
+}
+
+func TestReencryptKeys(t *testing.T) {
+	oldPub, oldPriv, _ := GenerateSecretKey()
+	newPub, newPriv, _ := GenerateSecretKey()
+	enc, _ := EncryptValue("hunter22", []*[32]byte{oldPub})
+	keys := map[string]KeyResponse{
+		"db:password": {"string", enc},
+		"db:creds":    {"hash", map[string]interface{}{"user": "app", "pass": enc}},
+		"db:host":     {"string", "db1"},
+	}
+	ts := newTreeServer(t, keys)
+	defer ts.Close()
+
+	c := InitiateClient(ts.URL)
+	if _, err := c.AdminReencryptKeys("db:*", []*[32]byte{newPub}); err != ErrNoSecretKey {
+		t.Errorf("Expected ErrNoSecretKey, got %v", err)
+	}
+	c.secretKey = oldPriv
+	count, err := c.AdminReencryptKeys("db:*", []*[32]byte{newPub})
+	if err != nil || count != 2 {
+		t.Fatalf("Expected 2 keys reencrypted, got %d: %v", count, err)
+	}
+
+	hash := keys["db:creds"].Data.(map[string]interface{})
+	for _, value := range []string{keys["db:password"].Data.(string), hash["pass"].(string)} {
+		if plain, err := DecryptValue(value, newPriv); err != nil || plain != "hunter22" {
+			t.Errorf("Expected value encrypted for the new key, got '%s': %v", plain, err)
+		}
+		if _, err := DecryptValue(value, oldPriv); err == nil {
+			t.Errorf("Expected value no longer encrypted for the old key")
+		}
+	}
+	if hash["user"] != "app" || keys["db:host"].Data != "db1" {
+		t.Errorf("Plain values changed: %v %v", hash, keys["db:host"])
+	}
+}
@@ -0,0 +1,230 @@
+package confclient
+
+import (
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DesiredState is the set of keys read from desired state files. Prefixes
+// are the key trees the files manage: keys below them which are not in Keys
+// are pruned.
+type DesiredState struct {
+	Keys     map[string]KeyResponse
+	Prefixes []string
+	// files records which file each key came from
+	files map[string]string
+}
+
+// stateFile is a YAML or TOML desired state file. Keys is a tree below
+// Prefix: tables/maps holding only scalar values are hashes, arrays are
+// lists, scalars are strings and any other table is a subtree whose names
+// are joined to the key with ':'.
+type stateFile struct {
+	Prefix string                 `yaml:"prefix" toml:"prefix"`
+	Keys   map[string]interface{} `yaml:"keys" toml:"keys"`
+}
+
+// ReadStateDir reads every .yaml, .yml and .toml file below dir. A key
+// defined by more than one file is an error.
+func ReadStateDir(dir string) (*DesiredState, error) {
+	state := &DesiredState{
+		Keys:  make(map[string]KeyResponse),
+		files: make(map[string]string),
+	}
+	files := make([]string, 0)
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		switch filepath.Ext(path) {
+		case ".yaml", ".yml", ".toml":
+			if fi.Mode().IsRegular() {
+				files = append(files, path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	for _, path := range files {
+		if err := state.readFile(path); err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(state.Prefixes)
+	return state, nil
+}
+
+func (s *DesiredState) readFile(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var sf stateFile
+	if filepath.Ext(path) == ".toml" {
+		err = toml.Unmarshal(b, &sf)
+	} else if err = yaml.Unmarshal(b, &sf); err == nil {
+		err = yamlStrings(strings.TrimSuffix(sf.Prefix, ":"), sf.Keys)
+	}
+	if err != nil {
+		return fmt.Errorf("Cannot parse %s: %s", path, err)
+	}
+
+	sf.Prefix = strings.TrimSuffix(sf.Prefix, ":")
+	if sf.Prefix != "" {
+		s.Prefixes = append(s.Prefixes, sf.Prefix)
+	}
+	if err := s.addTree(path, sf.Prefix, sf.Keys); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	return nil
+}
+
+func (s *DesiredState) addTree(path string, prefix string, tree map[string]interface{}) error {
+	for name, v := range tree {
+		key := name
+		if prefix != "" {
+			key = prefix + ":" + name
+		}
+		var kr KeyResponse
+		switch val := v.(type) {
+		case []interface{}:
+			l := make([]interface{}, len(val))
+			for i, item := range val {
+				str, ok := stateScalar(item)
+				if !ok {
+					return fmt.Errorf("list %s can only hold strings, numbers and booleans", key)
+				}
+				l[i] = str
+			}
+			kr = KeyResponse{Type: "list", Data: l}
+		case map[string]interface{}, map[interface{}]interface{}:
+			m := stateMap(val)
+			hash := make(map[string]interface{})
+			for field, fv := range m {
+				str, ok := stateScalar(fv)
+				if !ok {
+					hash = nil
+					break
+				}
+				hash[field] = str
+			}
+			if hash == nil {
+				if err := s.addTree(path, key, m); err != nil {
+					return err
+				}
+				continue
+			}
+			kr = KeyResponse{Type: "hash", Data: hash}
+		default:
+			str, ok := stateScalar(val)
+			if !ok {
+				return fmt.Errorf("key %s has no value", key)
+			}
+			kr = KeyResponse{Type: "string", Data: str}
+		}
+
+		if other, exists := s.files[key]; exists {
+			return fmt.Errorf("key %s is also defined in %s", key, other)
+		}
+		s.files[key] = path
+		s.Keys[key] = kr
+	}
+	return nil
+}
+
+// yamlStrings checks every scalar below the YAML value v of key name is a
+// string. YAML turns unquoted yes, no, on and off into booleans and 0755 into
+// 493, so only quoted values are kept as written.
+func yamlStrings(name string, v interface{}) error {
+	switch val := v.(type) {
+	case nil, string:
+		return nil
+	case []interface{}:
+		for i, item := range val {
+			if err := yamlStrings(fmt.Sprintf("%s[%d]", name, i), item); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]interface{}, map[interface{}]interface{}:
+		for k, item := range stateMap(val) {
+			child := k
+			if name != "" {
+				child = name + ":" + k
+			}
+			if err := yamlStrings(child, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("%s: %v is not a string, quote it as YAML reads unquoted yes, no, on, off and numbers such as 0755 differently", name, v)
+}
+
+// stateScalar returns the string value of a scalar read from a state file
+func stateScalar(v interface{}) (string, bool) {
+	switch val := v.(type) {
+	case nil, []interface{}, map[string]interface{}, map[interface{}]interface{}:
+		return "", false
+	case string:
+		return val, true
+	default:
+		return fmt.Sprint(val), true
+	}
+}
+
+// stateMap converts the maps decoded from YAML, which may have non-string
+// keys, into map[string]interface{}
+func stateMap(v interface{}) map[string]interface{} {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return m
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{})
+		for k, val := range m {
+			out[fmt.Sprint(k)] = val
+		}
+		return out
+	}
+	return nil
+}
+
+// AdminPlanState works out the changes which make the server match state.
+// If prune is set, keys below state.Prefixes which are not in state.Keys are
+// deleted.
+func (c *Client) AdminPlanState(state *DesiredState, prune bool) (*SyncPlan, error) {
+	current := make(map[string]KeyResponse)
+	for _, prefix := range state.Prefixes {
+		keys, err := c.adminGetKeys(DumpOptions{Filter: prefix + ":*"})
+		if err != nil {
+			return nil, err
+		}
+		for k, kr := range keys {
+			current[k] = kr
+		}
+	}
+	for _, k := range sortedKeyNames(state.Keys) {
+		if _, ok := current[k]; ok {
+			continue
+		}
+		kr, err := c.AdminGetKey(k)
+		if IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Cannot get key %s: %s", k, err)
+		}
+		current[k] = kr
+	}
+	return PlanSync(state.Keys, current, prune), nil
+}
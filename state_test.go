@@ -0,0 +1,108 @@
+package confclient
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadStateDir(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "confadm")
+	defer os.RemoveAll(dir)
+	writeTestFile(t, filepath.Join(dir, "ams1.yaml"), `
+prefix: sites:ams1
+keys:
+  name: Amsterdam
+  hosts: [a, b]
+  db:
+    host: db1
+    port: "5432"
+  vhosts:
+    www:
+      port: "80"
+    enabled: "yes"
+`)
+	writeTestFile(t, filepath.Join(dir, "global.toml"), `
+[keys]
+"global:ntp" = ["ntp1", "ntp2"]
+`)
+
+	state, err := ReadStateDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]KeyResponse{
+		"sites:ams1:name":           {"string", "Amsterdam"},
+		"sites:ams1:hosts":          {"list", []interface{}{"a", "b"}},
+		"sites:ams1:db":             {"hash", map[string]interface{}{"host": "db1", "port": "5432"}},
+		"sites:ams1:vhosts:www":     {"hash", map[string]interface{}{"port": "80"}},
+		"sites:ams1:vhosts:enabled": {"string", "yes"},
+		"global:ntp":                {"list", []interface{}{"ntp1", "ntp2"}},
+	}
+	if !reflect.DeepEqual(state.Keys, expected) {
+		t.Errorf("Expected %v, got %v", expected, state.Keys)
+	}
+	if !reflect.DeepEqual(state.Prefixes, []string{"sites:ams1"}) {
+		t.Errorf("Unexpected prefixes: %v", state.Prefixes)
+	}
+
+	writeTestFile(t, filepath.Join(dir, "dup.yaml"), "keys:\n  sites:ams1:name: x\n")
+	if _, err := ReadStateDir(dir); err == nil {
+		t.Errorf("Expected an error for a key defined twice")
+	}
+	os.Remove(filepath.Join(dir, "dup.yaml"))
+
+	writeTestFile(t, filepath.Join(dir, "mode.yaml"), "prefix: app\nkeys:\n  files:\n    mode: 0755\n")
+	if _, err := ReadStateDir(dir); err == nil || !strings.Contains(err.Error(), "app:files:mode: 493 is not a string") {
+		t.Errorf("Expected unquoted YAML numbers to be refused, got %v", err)
+	}
+}
+
+func TestApplyState(t *testing.T) {
+	state := &DesiredState{
+		Keys: map[string]KeyResponse{
+			"sites:ams1:name":  {"string", "Amsterdam"},
+			"sites:ams1:hosts": {"list", []interface{}{"a", "b", "c"}},
+			"sites:ams1:db":    {"hash", map[string]interface{}{"host": "db1", "port": "5432"}},
+		},
+		Prefixes: []string{"sites:ams1"},
+	}
+	keys := map[string]KeyResponse{
+		"sites:ams1:hosts": {"list", []interface{}{"a"}},
+		"sites:ams1:db":    {"hash", map[string]interface{}{"host": "db0", "port": "5432"}},
+		"sites:ams1:old":   {"string", "x"},
+		"sites:lon1:name":  {"string", "London"},
+	}
+	ts := newTreeServer(t, keys)
+	defer ts.Close()
+	c := InitiateClient(ts.URL)
+
+	plan, err := c.AdminPlanState(state, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Summary() != "1 to create, 2 to update, 1 to delete" {
+		t.Errorf("Unexpected plan: %s", plan.Summary())
+	}
+	if err := c.AdminApplyPlan(plan); err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range plan.Steps {
+		if step.Status != StepApplied {
+			t.Errorf("Expected %s to be applied, got '%s'", step.Key, step.Status)
+		}
+	}
+
+	expected := map[string]KeyResponse{
+		"sites:ams1:name":  {"string", "Amsterdam"},
+		"sites:ams1:hosts": {"list", []interface{}{"a", "b", "c"}},
+		"sites:ams1:db":    {"hash", map[string]interface{}{"host": "db1", "port": "5432"}},
+		"sites:lon1:name":  {"string", "London"},
+	}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Expected %v, got %v", expected, keys)
+	}
+}
@@ -0,0 +1,179 @@
+package confclient
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"strings"
+)
+
+// Plan actions
+const (
+	PlanCreate = "create"
+	PlanUpdate = "update"
+	PlanDelete = "delete"
+)
+
+// Plan step statuses set by AdminApplyPlan
+const (
+	StepApplied  = "applied"
+	StepConflict = "conflict"
+)
+
+// PlanStep is one change to bring a destination key in line with its
+// source. Expected is the destination value the plan was computed against,
+// nil for creates. Status is set once the plan is applied.
+type PlanStep struct {
+	Key      string       `json:"key"`
+	Action   string       `json:"action"`
+	Value    *KeyResponse `json:"value,omitempty"`
+	Expected *KeyResponse `json:"expected,omitempty"`
+	Diff     KeyDiff      `json:"diff"`
+	Status   string       `json:"status,omitempty"`
+}
+
+// SyncPlan is the list of changes which make a destination match a source,
+// ordered by key name. SkippedDeletes counts destination keys missing from
+// the source which are kept because deletes were not requested.
+type SyncPlan struct {
+	Steps          []PlanStep `json:"steps"`
+	SkippedDeletes int        `json:"skipped_deletes"`
+}
+
+// ConflictError is returned when a key changed after a plan or edit was
+// based on it, so it was not overwritten
+type ConflictError struct {
+	Keys []string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("Keys changed since they were read, not overwritten: %s", strings.Join(e.Keys, ", "))
+}
+
+// PlanSync works out the creates, updates and, if deletes is set, deletes
+// which make dst match src
+func PlanSync(src map[string]KeyResponse, dst map[string]KeyResponse, deletes bool) *SyncPlan {
+	plan := &SyncPlan{Steps: make([]PlanStep, 0)}
+	for _, d := range DiffKeys(dst, src) {
+		step := PlanStep{Key: d.Key, Diff: d}
+		switch d.Change {
+		case DiffAdded:
+			step.Action = PlanCreate
+		case DiffRemoved:
+			if !deletes {
+				plan.SkippedDeletes++
+				continue
+			}
+			step.Action = PlanDelete
+		default:
+			step.Action = PlanUpdate
+		}
+		if kr, ok := src[d.Key]; ok {
+			step.Value = &kr
+		}
+		if kr, ok := dst[d.Key]; ok {
+			step.Expected = &kr
+		}
+		plan.Steps = append(plan.Steps, step)
+	}
+	return plan
+}
+
+// Count returns the number of steps with the given action
+func (p *SyncPlan) Count(action string) int {
+	n := 0
+	for _, s := range p.Steps {
+		if s.Action == action {
+			n++
+		}
+	}
+	return n
+}
+
+// Summary is a one line count of each action
+func (p *SyncPlan) Summary() string {
+	summary := fmt.Sprintf("%d to create, %d to update, %d to delete",
+		p.Count(PlanCreate), p.Count(PlanUpdate), p.Count(PlanDelete))
+	if p.SkippedDeletes > 0 {
+		summary += fmt.Sprintf(" (%d keys only in the destination kept)", p.SkippedDeletes)
+	}
+	return summary
+}
+
+// Diffs returns the differences each step resolves
+func (p *SyncPlan) Diffs() []KeyDiff {
+	diffs := make([]KeyDiff, len(p.Steps))
+	for i, s := range p.Steps {
+		diffs[i] = s.Diff
+	}
+	return diffs
+}
+
+// AdminApplyPlan applies plan to the server and sets the Status of each
+// step. Keys are written IfUnchanged the value the plan expected, and keys
+// which changed since are left alone and returned in a *ConflictError once
+// all other steps are applied.
+func (c *Client) AdminApplyPlan(plan *SyncPlan) error {
+	conflicts := make([]string, 0)
+	for i := range plan.Steps {
+		step := &plan.Steps[i]
+		version := VersionAbsent
+		if step.Expected != nil {
+			version = KeyVersion(*step.Expected)
+		}
+
+		var err error
+		if step.Action == PlanDelete {
+			err = c.IfUnchanged(version).AdminDeleteKey(step.Key)
+		} else {
+			err = c.IfUnchanged(version).applyStep(step)
+		}
+		if IsConflict(err) {
+			log.WithFields(log.Fields{"key": step.Key}).Warn("Key changed since the plan was made")
+			conflicts = append(conflicts, step.Key)
+			step.Status = StepConflict
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("Cannot %s key %s: %s", step.Action, step.Key, err)
+		}
+		step.Status = StepApplied
+		log.WithFields(log.Fields{"key": step.Key, "action": step.Action}).Debug("Applied plan step")
+	}
+	if len(conflicts) > 0 {
+		return &ConflictError{Keys: conflicts}
+	}
+	return nil
+}
+
+// applyStep writes a create or update step with a client IfUnchanged the
+// step's expected value. An update which only adds or changes one hash field,
+// or only appends one list item, is made with that single write; anything
+// else replaces the whole key, so a conflict never leaves it half updated.
+func (c *Client) applyStep(step *PlanStep) error {
+	if step.Action == PlanUpdate && len(step.Diff.Fields) == 1 && step.Diff.Fields[0].Change != DiffRemoved {
+		f := step.Diff.Fields[0]
+		value, ok := f.Dst.(string)
+		if !ok {
+			return fmt.Errorf("Cannot set hash field %s: %T is not a string", f.Field, f.Dst)
+		}
+		return c.AdminSetHashField(step.Key, f.Field, value)
+	}
+	if step.Action == PlanUpdate && len(step.Diff.Elements) == 1 {
+		expected, _ := step.Expected.Data.([]interface{})
+		e := step.Diff.Elements[0]
+		if e.Change == DiffAdded && e.Index >= len(expected) {
+			value, ok := e.Value.(string)
+			if !ok {
+				return fmt.Errorf("Cannot append list item %d: %T is not a string", e.Index, e.Value)
+			}
+			return c.AdminListAppend(step.Key, value)
+		}
+	}
+
+	jsonblob, err := json.Marshal(step.Value)
+	if err != nil {
+		return err
+	}
+	return c.AdminSetKeyFromJSON(step.Key, jsonblob)
+}
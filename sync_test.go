@@ -0,0 +1,77 @@
+package confclient
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPlanAndApplySync(t *testing.T) {
+	src := map[string]KeyResponse{
+		"sites:ams1:name":  {"string", "Amsterdam"},
+		"sites:ams1:hosts": {"list", []interface{}{"a", "b", "c"}},
+		"sites:ams1:db":    {"hash", map[string]interface{}{"host": "db1"}},
+	}
+	dst := map[string]KeyResponse{
+		"sites:ams1:name":  {"string", "Amsterdam"},
+		"sites:ams1:hosts": {"list", []interface{}{"a"}},
+		"sites:ams1:db":    {"hash", map[string]interface{}{"host": "db0"}},
+		"sites:ams1:old":   {"string", "x"},
+	}
+
+	plan := PlanSync(src, dst, false)
+	if plan.Summary() != "0 to create, 2 to update, 0 to delete (1 keys only in the destination kept)" {
+		t.Errorf("Unexpected plan: %s", plan.Summary())
+	}
+	plan = PlanSync(src, dst, true)
+	actions := make(map[string]string)
+	for _, s := range plan.Steps {
+		actions[s.Key] = s.Action
+	}
+	expected := map[string]string{"sites:ams1:hosts": PlanUpdate, "sites:ams1:db": PlanUpdate, "sites:ams1:old": PlanDelete}
+	if !reflect.DeepEqual(actions, expected) {
+		t.Errorf("Expected %v, got %v", expected, actions)
+	}
+
+	server := make(map[string]KeyResponse)
+	for k, v := range dst {
+		server[k] = v
+	}
+	ts := newTreeServer(t, server)
+	defer ts.Close()
+
+	// Someone else changes a key between plan and apply
+	server["sites:ams1:db"] = KeyResponse{"hash", map[string]interface{}{"host": "db9"}}
+	err := InitiateClient(ts.URL).AdminApplyPlan(plan)
+	conflict, ok := err.(*ConflictError)
+	if !ok || !reflect.DeepEqual(conflict.Keys, []string{"sites:ams1:db"}) {
+		t.Fatalf("Expected a conflict on sites:ams1:db, got %v", err)
+	}
+	if server["sites:ams1:db"].Data.(map[string]interface{})["host"] != "db9" {
+		t.Errorf("Conflicting key was overwritten")
+	}
+	if !reflect.DeepEqual(server["sites:ams1:hosts"], src["sites:ams1:hosts"]) {
+		t.Errorf("Expected hosts to be updated, got %v", server["sites:ams1:hosts"])
+	}
+	if _, ok := server["sites:ams1:old"]; ok {
+		t.Errorf("Expected sites:ams1:old to be deleted")
+	}
+}
+
+func TestApplySyncRefusesNonStringValues(t *testing.T) {
+	src := map[string]KeyResponse{
+		"sites:ams1:db": {"hash", map[string]interface{}{"host": map[string]interface{}{"name": "db1"}}},
+	}
+	server := map[string]KeyResponse{
+		"sites:ams1:db": {"hash", map[string]interface{}{"host": "db0"}},
+	}
+	plan := PlanSync(src, server, false)
+	ts := newTreeServer(t, server)
+	defer ts.Close()
+
+	if err := InitiateClient(ts.URL).AdminApplyPlan(plan); err == nil {
+		t.Fatalf("Expected a non-string hash field to be refused")
+	}
+	if server["sites:ams1:db"].Data.(map[string]interface{})["host"] != "db0" {
+		t.Errorf("Expected sites:ams1:db to be unchanged, got %v", server["sites:ams1:db"])
+	}
+}
@@ -0,0 +1,141 @@
+package confclient
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// VersionAbsent is the version of a key which does not exist
+const VersionAbsent = "absent"
+
+// versionHashPrefix marks versions computed by KeyVersion rather than sent
+// by the server as an ETag. ETags are always quoted, so they cannot clash.
+const versionHashPrefix = "sha256:"
+
+// KeyVersion is the version of a key computed from its value, used when the
+// server does not send an ETag
+func KeyVersion(kr KeyResponse) string {
+	// Maps are marshalled with sorted keys, so equal keys hash the same
+	b, _ := json.Marshal(kr)
+	sum := sha256.Sum256(b)
+	return versionHashPrefix + hex.EncodeToString(sum[:])
+}
+
+// IsConflict reports whether err means a conditional write was refused
+// because the key changed, see IfUnchanged
+func IsConflict(err error) bool {
+	if _, ok := err.(*ConflictError); ok {
+		return true
+	}
+	if e, ok := err.(*BatchError); ok {
+		return IsConflict(e.Err)
+	}
+	return err != nil && strings.Contains(err.Error(), "HTTP Error 412")
+}
+
+// AdminGetKeyVersion gets a key and its version: the ETag the server sent
+// with it, or else KeyVersion of its value. A key which does not exist has
+// VersionAbsent, returned along with the not found error.
+func (c *Client) AdminGetKeyVersion(keyName string) (KeyResponse, string, error) {
+	var keyResponse KeyResponse
+
+	resp, header, err := c.getRequest(fmt.Sprintf("/admin/key/%s", keyName), "application/json")
+	if IsNotFound(err) {
+		return keyResponse, VersionAbsent, err
+	}
+	if err != nil {
+		return keyResponse, "", err
+	}
+	if err = json.Unmarshal(resp, &keyResponse); err != nil {
+		return keyResponse, "", err
+	}
+	registerKeySecrets(keyName, keyResponse)
+
+	if etag := header.Get("ETag"); etag != "" {
+		return keyResponse, etag, nil
+	}
+	return keyResponse, KeyVersion(keyResponse), nil
+}
+
+// IfUnchanged returns a copy of the client whose admin writes only change a
+// key while it still has version, as returned by AdminGetKeyVersion. ETags
+// are sent to the server as If-Match; computed versions are compared with
+// the key read again just before the write. A key which changed is not
+// written and a *ConflictError is returned.
+func (c *Client) IfUnchanged(version string) *Client {
+	conditional := *c
+	conditional.ifVersion = version
+	return &conditional
+}
+
+// checkUnchanged compares keyName with the version the client's writes are
+// conditional on, unless the server checks it from If-Match
+func (c *Client) checkUnchanged(keyName string) error {
+	if c.ifVersion == "" || isETag(c.ifVersion) {
+		return nil
+	}
+	kr, version, err := c.AdminGetKeyVersion(keyName)
+	if err != nil && !IsNotFound(err) {
+		return fmt.Errorf("Cannot get key %s: %s", keyName, err)
+	}
+	if !c.hasVersion(kr, version) {
+		return &ConflictError{Keys: []string{keyName}}
+	}
+	return nil
+}
+
+// hasVersion reports whether a key read with AdminGetKeyVersion still has
+// the version the client's writes are conditional on. Versions computed by
+// KeyVersion are compared with the value, as the server may send ETags.
+func (c *Client) hasVersion(kr KeyResponse, version string) bool {
+	if version != VersionAbsent && strings.HasPrefix(c.ifVersion, versionHashPrefix) {
+		version = KeyVersion(kr)
+	}
+	return version == c.ifVersion
+}
+
+// conflictError turns the server refusing an If-Match write into a
+// *ConflictError for keyName
+func conflictError(keyName string, err error) error {
+	if err != nil && strings.Contains(err.Error(), "HTTP Error 412") {
+		return &ConflictError{Keys: []string{keyName}}
+	}
+	return err
+}
+
+// adminGetForUpdate gets a key to change and write back with the client
+// IfUnchanged its returned version. If the client's writes are already
+// conditional, the key must still have that version.
+func (c *Client) adminGetForUpdate(keyName string) (KeyResponse, string, error) {
+	kr, version, err := c.AdminGetKeyVersion(keyName)
+	if err != nil && !IsNotFound(err) {
+		return kr, version, fmt.Errorf("Cannot get key %s: %s", keyName, err)
+	}
+	if c.ifVersion != "" && !c.hasVersion(kr, version) {
+		return kr, version, &ConflictError{Keys: []string{keyName}}
+	}
+	return kr, version, err
+}
+
+// adminSetKey writes kr to keyName
+func (c *Client) adminSetKey(keyName string, kr KeyResponse) error {
+	jsonblob, err := json.Marshal(kr)
+	if err != nil {
+		return err
+	}
+	return c.AdminSetKeyFromJSON(keyName, jsonblob)
+}
+
+func (c *Client) setIfMatch(req *http.Request) {
+	if isETag(c.ifVersion) {
+		req.Header.Set("If-Match", c.ifVersion)
+	}
+}
+
+func isETag(version string) bool {
+	return version != "" && version != VersionAbsent && !strings.HasPrefix(version, versionHashPrefix)
+}
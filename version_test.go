@@ -0,0 +1,78 @@
+package confclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIfUnchangedComparesValues(t *testing.T) {
+	keys := map[string]KeyResponse{
+		"app:db": {"hash", map[string]interface{}{"host": "db1"}},
+	}
+	ts := newTreeServer(t, keys)
+	defer ts.Close()
+	c := InitiateClient(ts.URL)
+
+	_, version, err := c.AdminGetKeyVersion("app:db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.IfUnchanged(version).AdminSetHashField("app:db", "port", "5432"); err != nil {
+		t.Fatal(err)
+	}
+	err = c.IfUnchanged(version).AdminSetHashField("app:db", "host", "db2")
+	if _, ok := err.(*ConflictError); !ok || !IsConflict(err) {
+		t.Errorf("Expected a conflict, got %v", err)
+	}
+	if keys["app:db"].Data.(map[string]interface{})["host"] != "db1" {
+		t.Errorf("Expected app:db not to be overwritten, got %v", keys["app:db"])
+	}
+
+	if err := c.IfUnchanged(VersionAbsent).AdminSetStringKey("app:name", "x"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.IfUnchanged(VersionAbsent).AdminSetStringKey("app:name", "y"); !IsConflict(err) {
+		t.Errorf("Expected a conflict creating an existing key, got %v", err)
+	}
+	if err := c.IfUnchanged(version).AdminListPrepend("app:db", "x"); !IsConflict(err) {
+		t.Errorf("Expected a conflict, got %v", err)
+	}
+}
+
+func TestIfUnchangedSendsIfMatch(t *testing.T) {
+	etag := `"1"`
+	value := KeyResponse{"string", "a"}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			w.Header().Set("ETag", etag)
+			json.NewEncoder(w).Encode(value)
+			return
+		}
+		if r.Header.Get("If-Match") != etag {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&value)
+		etag = fmt.Sprintf(`"%s"`, value.Data)
+	}))
+	defer ts.Close()
+	c := InitiateClient(ts.URL)
+
+	_, version, err := c.AdminGetKeyVersion("app:name")
+	if err != nil || version != `"1"` {
+		t.Fatalf("Expected the ETag as version, got %q: %v", version, err)
+	}
+	if err := c.IfUnchanged(version).AdminSetStringKey("app:name", "b"); err != nil {
+		t.Fatal(err)
+	}
+	err = c.IfUnchanged(version).AdminSetKeyFromJSON("app:name", []byte(`{"type":"string","data":"c"}`))
+	if e, ok := err.(*ConflictError); !ok || e.Keys[0] != "app:name" {
+		t.Errorf("Expected a conflict, got %v", err)
+	}
+	if value.Data != "b" {
+		t.Errorf("Expected b, got %v", value.Data)
+	}
+}